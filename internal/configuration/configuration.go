@@ -30,6 +30,20 @@ type ApplicationConfig struct {
 	BasePath       string    `yaml:"basePath"`
 	WebPort        int       `yaml:"webPort"`
 	TrustedProxies []string  `yaml:"trustedProxies"`
+	LegacyExport   bool      `yaml:"legacyExport"`
+	MasterInclude  string    `yaml:"masterInclude"`
+	ReloadCommand  string    `yaml:"reloadCommand"`
+	BackupRetention  RetentionConfig `yaml:"backupRetention"`
+	DBBackupInterval time.Duration   `yaml:"dbBackupInterval"`
+	Feeds            []FeedConfig    `yaml:"feeds"`
+	ExportTemplates  []ExportTemplate `yaml:"exportTemplates"`
+	ModSecurity      ModSecurityConfig `yaml:"modSecurity"`
+	AWSWAF           AWSWAFConfig      `yaml:"awsWaf"`
+	Cloudflare       CloudflareConfig  `yaml:"cloudflare"`
+	Users            []UserConfig      `yaml:"users"`
+	Session          SessionConfig     `yaml:"session"`
+	LDAP             LDAPConfig        `yaml:"ldap"`
+	OIDC             OIDCConfig        `yaml:"oidc"`
 	DateLayout          string    `yaml:"DateLayout"`
 	OutputFolder        string    `yaml:"OutputFolder"`
 	DefaultFile2analyze string    `yaml:"DefaultLog2analyze"`
@@ -38,6 +52,133 @@ type ApplicationConfig struct {
 	Logcfg         LogConfig `yaml:"LogConfig"`
 }
 
+// RetentionConfig legt fest, wie viele Backups behalten werden: die letzten
+// Last Backups sowie das jeweils neueste der letzten Daily Tage, Weekly Wochen
+// und Monthly Monate. Sind alle Werte 0, wird nichts gelöscht.
+type RetentionConfig struct {
+	Last    int `yaml:"last"`
+	Daily   int `yaml:"daily"`
+	Weekly  int `yaml:"weekly"`
+	Monthly int `yaml:"monthly"`
+}
+
+// FeedConfig beschreibt einen Pool, der regelmässig aus einer entfernten
+// Liste wie Spamhaus DROP oder FireHOL level1 gepflegt wird
+type FeedConfig struct {
+	Name     string        `yaml:"name"`     // Name des Pools
+	URL      string        `yaml:"url"`
+	Format   string        `yaml:"format"`   // plain oder apache
+	Status   string        `yaml:"status"`   // b oder w
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ExportTemplate beschreibt ein zusätzliches Exportformat als text/template.
+// Template und FileName werden je Pool und Status mit .Pool, .Status und
+// .Entries gerendert, die Dateien landen in OutputDir.
+type ExportTemplate struct {
+	Name         string `yaml:"name"`
+	Template     string `yaml:"template"`
+	TemplateFile string `yaml:"templateFile"` // statt template
+	FileName     string `yaml:"fileName"`
+	OutputDir    string `yaml:"outputDir"`
+}
+
+// ModSecurityConfig legt fest, ob und wie die Pools als ipMatchFromFile-Listen
+// mit einer SecRule je Pool und Status exportiert werden. PoolActions
+// überschreibt die Aktionen für einzelne Pools, RuleIDs legt die Regel-ID
+// einzelner Listen (z.B. blocklist-scanner) fest.
+type ModSecurityConfig struct {
+	Enabled          bool              `yaml:"enabled"`
+	OutputDir        string            `yaml:"outputDir"`
+	RulesFile        string            `yaml:"rulesFile"`
+	IDBase           int               `yaml:"idBase"`
+	Phase            int               `yaml:"phase"`
+	Variable         string            `yaml:"variable"`
+	BlockActions     string            `yaml:"blockActions"`
+	WhitelistActions string            `yaml:"whitelistActions"`
+	PoolActions      map[string]string `yaml:"poolActions"`
+	RuleIDs          map[string]int    `yaml:"ruleIds"`
+}
+
+// AWSWAFConfig legt fest, ob die Pools als AWS WAFv2 IPSets (JSON für
+// "aws wafv2 create-ip-set --cli-input-json") exportiert werden
+type AWSWAFConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	OutputDir    string `yaml:"outputDir"`
+	Scope        string `yaml:"scope"` // CLOUDFRONT oder REGIONAL
+	NamePrefix   string `yaml:"namePrefix"`
+	MaxAddresses int    `yaml:"maxAddresses"`
+}
+
+// CloudflareConfig legt fest, ob die Pools im Bulk-Format für Cloudflare
+// IP-Listen exportiert werden
+type CloudflareConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	OutputDir string `yaml:"outputDir"`
+	MaxItems  int    `yaml:"maxItems"`
+}
+
+// UserConfig ist ein Benutzer des Admin-Bereichs, der in der Konfiguration
+// statt in der Datenbank gepflegt wird. Den bcrypt-Hash erzeugt
+// "blv -hashPassword".
+type UserConfig struct {
+	Name         string   `yaml:"name"`
+	PasswordHash string   `yaml:"passwordHash"`
+	Role         string   `yaml:"role"`  // viewer, editor oder admin
+	Pools        []string `yaml:"pools"` // leer für alle Pools
+}
+
+// SessionConfig legt fest, wie lange eine Anmeldung im Admin-Bereich gilt.
+// InsecureCookie erlaubt das Session-Cookie auch ohne HTTPS, z.B. für Tests.
+type SessionConfig struct {
+	IdleTimeout    time.Duration `yaml:"idleTimeout"`
+	MaxAge         time.Duration `yaml:"maxAge"`
+	InsecureCookie bool          `yaml:"insecureCookie"`
+}
+
+// LDAPConfig beschreibt die Anmeldung gegen einen LDAP-Server. Der Benutzer
+// wird mit BindDN über UserFilter unter BaseDN gesucht und mit seinem Passwort
+// gebunden, die Rolle ergibt sich aus seinen Gruppen in GroupAttribute.
+type LDAPConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	URL                string        `yaml:"url"`      // ldap://host:389 oder ldaps://host:636
+	StartTLS           bool          `yaml:"startTLS"` // nur mit ldap://
+	CACert             string        `yaml:"caCert"`   // PEM-Datei, sonst die System-CAs
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify"`
+	BindDN             string        `yaml:"bindDN"` // leer für anonyme Suche
+	BindPassword       string        `yaml:"bindPassword"`
+	BaseDN             string        `yaml:"baseDN"`
+	UserFilter         string        `yaml:"userFilter"` // %s wird durch den Benutzernamen ersetzt
+	GroupAttribute     string        `yaml:"groupAttribute"`
+	GroupRoles         []GroupRole   `yaml:"groupRoles"`
+	Timeout            time.Duration `yaml:"timeout"`
+}
+
+// GroupRole ordnet Mitgliedern einer Gruppe (LDAP: DN, OIDC: Wert im
+// Claim) eine Rolle zu, optional nur für einzelne Pools
+type GroupRole struct {
+	Group string   `yaml:"group"`
+	Role  string   `yaml:"role"`
+	Pools []string `yaml:"pools"`
+}
+
+// OIDCConfig beschreibt die Anmeldung über OpenID Connect (Authorization Code
+// Flow mit PKCE). RedirectURL ist die von aussen erreichbare Adresse von
+// /admin/oidc/callback, die Rolle ergibt sich aus den Werten in RoleClaim.
+type OIDCConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Issuer        string        `yaml:"issuer"`
+	ClientID      string        `yaml:"clientID"`
+	ClientSecret  string        `yaml:"clientSecret"` // leer für öffentliche Clients
+	RedirectURL   string        `yaml:"redirectURL"`
+	Scopes        []string      `yaml:"scopes"`
+	UsernameClaim string        `yaml:"usernameClaim"`
+	RoleClaim     string        `yaml:"roleClaim"`
+	GroupRoles    []GroupRole   `yaml:"groupRoles"`
+	Label         string        `yaml:"label"` // Beschriftung auf der Anmeldeseite
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
 type LogConfig struct {
 	LogLevel  string `yaml:"LogLevel"`
 	LogFolder string `yaml:"LogFolder"`
@@ -75,6 +216,46 @@ func (config *ApplicationConfig) setDefaults() {
 		BasePath:       "",
 		WebPort:        8080,
 		TrustedProxies: []string{"127.0.0.1"},
+		MasterInclude:  "blv.conf",
+		BackupRetention: RetentionConfig{
+			Last:    10,
+			Daily:   7,
+			Weekly:  4,
+			Monthly: 12,
+		},
+		DBBackupInterval: 24 * time.Hour,
+		ModSecurity: ModSecurityConfig{
+			RulesFile:        "blv-modsecurity.conf",
+			IDBase:           10000,
+			Phase:            1,
+			Variable:         "REMOTE_ADDR",
+			BlockActions:     "deny,status:403,log",
+			WhitelistActions: "allow,nolog",
+		},
+		AWSWAF: AWSWAFConfig{
+			Scope:        "CLOUDFRONT",
+			NamePrefix:   "blv",
+			MaxAddresses: 10000,
+		},
+		Cloudflare: CloudflareConfig{
+			MaxItems: 10000,
+		},
+		Session: SessionConfig{
+			IdleTimeout: 30 * time.Minute,
+			MaxAge:      12 * time.Hour,
+		},
+		LDAP: LDAPConfig{
+			UserFilter:     "(uid=%s)",
+			GroupAttribute: "memberOf",
+			Timeout:        10 * time.Second,
+		},
+		OIDC: OIDCConfig{
+			Scopes:        []string{"openid", "profile", "email"},
+			UsernameClaim: "preferred_username",
+			RoleClaim:     "groups",
+			Label:         "Single Sign-On",
+			Timeout:       10 * time.Second,
+		},
 		DateLayout:   "02/Jan/2006:15:04:05 -0700",
 		OutputFolder: "./output/",
 		LogType:      "apache",
@@ -96,6 +277,54 @@ func (c *ApplicationConfig) CheckConfig() {
 	if !helpers.CheckIfDir(c.Logcfg.LogFolder) {
 		helpers.ToBeCreated(c.Logcfg.LogFolder)
 	}
+	if c.LDAP.Enabled {
+		if c.LDAP.URL == "" || c.LDAP.BaseDN == "" {
+			log.Fatalln("ERROR ldap: url und baseDN müssen gesetzt sein")
+		}
+		if !strings.Contains(c.LDAP.UserFilter, "%s") {
+			log.Fatalf("ERROR ldap: userFilter %q braucht %%s für den Benutzernamen", c.LDAP.UserFilter)
+		}
+	}
+	if c.OIDC.Enabled && (c.OIDC.Issuer == "" || c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "") {
+		log.Fatalln("ERROR oidc: issuer, clientID und redirectURL müssen gesetzt sein")
+	}
+	for i := range c.Users {
+		if c.Users[i].Role == "" {
+			c.Users[i].Role = "viewer"
+		}
+	}
+	for i := range c.Feeds {
+		if c.Feeds[i].Format == "" {
+			c.Feeds[i].Format = "plain"
+		}
+		if c.Feeds[i].Status == "" {
+			c.Feeds[i].Status = "b"
+		}
+		if c.Feeds[i].Interval == 0 {
+			c.Feeds[i].Interval = 24 * time.Hour
+		}
+	}
+	for i := range c.ExportTemplates {
+		if c.ExportTemplates[i].FileName == "" {
+			c.ExportTemplates[i].FileName = "{{ .Pool }}-{{ .Status }}.txt"
+		}
+		if c.ExportTemplates[i].OutputDir == "" {
+			c.ExportTemplates[i].OutputDir = c.OutputPath + c.ExportTemplates[i].Name
+		}
+		helpers.Checknaddtrailingslash(&c.ExportTemplates[i].OutputDir)
+	}
+	if c.ModSecurity.OutputDir == "" {
+		c.ModSecurity.OutputDir = c.OutputPath + "modsecurity"
+	}
+	helpers.Checknaddtrailingslash(&c.ModSecurity.OutputDir)
+	if c.AWSWAF.OutputDir == "" {
+		c.AWSWAF.OutputDir = c.OutputPath + "aws"
+	}
+	helpers.Checknaddtrailingslash(&c.AWSWAF.OutputDir)
+	if c.Cloudflare.OutputDir == "" {
+		c.Cloudflare.OutputDir = c.OutputPath + "cloudflare"
+	}
+	helpers.Checknaddtrailingslash(&c.Cloudflare.OutputDir)
 	helpers.Checknaddtrailingslash(&c.OutputFolder)
 	// check if the output folder exists
 	if !helpers.CheckIfDir(c.OutputFolder) {
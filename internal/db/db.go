@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
 	// _ "github.com/mattn/go-sqlite3"
@@ -21,9 +22,26 @@ type PoolEntry struct {
 	Name       string
 	Comment    string
 	Status     string
+	GroupID    int64
+	ExpiresAt  string // JJJJ-MM-TT, leer für unbefristet
 	CheckedIP  string
 }
 
+// Spalten eines Pool-Eintrags in der Reihenfolge von scanEntry
+const entryColumns = "id, start_ip_int, end_ip_int, cidr, name, comment, status, IFNULL(group_id, 0), expires_at"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row rowScanner) (*PoolEntry, error) {
+	p := &PoolEntry{}
+	if err := row.Scan(&p.ID, &p.StartIPInt, &p.EndIPInt, &p.CIDR, &p.Name, &p.Comment, &p.Status, &p.GroupID, &p.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func Open(path string) (*sql.DB, error) {
 	return sql.Open("sqlite", fmt.Sprintf("file:%s?_journal_mode=WAL", path))
 }
@@ -49,7 +67,9 @@ func CreateTables(database *sql.DB) error {
 	       cidr TEXT NOT NULL,
 	       name TEXT,
 	       comment TEXT,
-	       status TEXT
+	       status TEXT,
+	       group_id INTEGER,
+	       expires_at TEXT NOT NULL DEFAULT ''
 	   );
 	   CREATE INDEX IF NOT EXISTS idx_ip_range ON pools (start_ip_int, end_ip_int);
 	   CREATE TABLE IF NOT EXISTS lut (
@@ -58,12 +78,154 @@ func CreateTables(database *sql.DB) error {
 	       name TEXT
 	   );
 	   CREATE INDEX IF NOT EXISTS host_name ON lut (name);
+	   CREATE TABLE IF NOT EXISTS list_files (
+	       path TEXT PRIMARY KEY,
+	       checksum TEXT NOT NULL,
+	       content TEXT NOT NULL,
+	       written_at TEXT NOT NULL
+	   );
+	   CREATE TABLE IF NOT EXISTS export_files (
+	       path TEXT PRIMARY KEY,
+	       written_at TEXT NOT NULL
+	   );
+	   CREATE TABLE IF NOT EXISTS modsec_rule_ids (
+	       pool TEXT NOT NULL,
+	       status TEXT NOT NULL,
+	       id_offset INTEGER NOT NULL UNIQUE,
+	       PRIMARY KEY (pool, status)
+	   );
+	   CREATE TABLE IF NOT EXISTS feeds (
+	       name TEXT PRIMARY KEY,
+	       etag TEXT NOT NULL DEFAULT '',
+	       last_modified TEXT NOT NULL DEFAULT '',
+	       last_fetch TEXT NOT NULL DEFAULT '',
+	       last_success TEXT NOT NULL DEFAULT '',
+	       last_error TEXT NOT NULL DEFAULT '',
+	       entries INTEGER NOT NULL DEFAULT 0
+	   );
+	   CREATE TABLE IF NOT EXISTS feed_history (
+	       id INTEGER PRIMARY KEY AUTOINCREMENT,
+	       name TEXT NOT NULL,
+	       fetched_at TEXT NOT NULL,
+	       http_status INTEGER NOT NULL,
+	       result TEXT NOT NULL,
+	       added INTEGER NOT NULL DEFAULT 0,
+	       removed INTEGER NOT NULL DEFAULT 0,
+	       changed INTEGER NOT NULL DEFAULT 0,
+	       message TEXT NOT NULL DEFAULT ''
+	   );
+	   CREATE INDEX IF NOT EXISTS idx_feed_history ON feed_history (name, id);
+	   CREATE TABLE IF NOT EXISTS api_tokens (
+	       id INTEGER PRIMARY KEY AUTOINCREMENT,
+	       name TEXT NOT NULL UNIQUE,
+	       hash TEXT NOT NULL UNIQUE,
+	       scopes TEXT NOT NULL,
+	       pools TEXT NOT NULL DEFAULT '',
+	       expires_at TEXT NOT NULL DEFAULT '',
+	       last_used TEXT NOT NULL DEFAULT '',
+	       created_at TEXT NOT NULL
+	   );
+	   CREATE TABLE IF NOT EXISTS users (
+	       username TEXT PRIMARY KEY,
+	       password_hash TEXT NOT NULL,
+	       role TEXT NOT NULL DEFAULT 'viewer',
+	       pools TEXT NOT NULL DEFAULT '',
+	       source TEXT NOT NULL DEFAULT 'local',
+	       last_login TEXT NOT NULL DEFAULT '',
+	       created_at TEXT NOT NULL
+	   );
+	   CREATE TABLE IF NOT EXISTS sessions (
+	       hash TEXT PRIMARY KEY,
+	       username TEXT NOT NULL,
+	       csrf TEXT NOT NULL,
+	       created_at TEXT NOT NULL,
+	       last_seen TEXT NOT NULL
+	   );
+	   CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (username);
 	   `
 	_, err := database.Exec(sqlStmt)
 	return err
 }
 
+// MigrateTables legt fehlende Tabellen an und ergänzt Spalten, die in älteren
+// Datenbanken noch fehlen
+func MigrateTables(database *sql.DB) error {
+	app.LogIt.Debug("MigrateTables")
+	if err := CreateTables(database); err != nil {
+		return err
+	}
+	hasColumn, err := columnExists(database, "pools", "group_id")
+	if err != nil {
+		return err
+	}
+	if !hasColumn {
+		app.LogIt.Info("ergänze Spalte group_id in pools")
+		if _, err := database.Exec(`ALTER TABLE pools ADD COLUMN group_id INTEGER`); err != nil {
+			return err
+		}
+	}
+	if _, err = database.Exec(`CREATE INDEX IF NOT EXISTS idx_group ON pools (group_id)`); err != nil {
+		return err
+	}
+	hasColumn, err = columnExists(database, "pools", "expires_at")
+	if err != nil {
+		return err
+	}
+	if !hasColumn {
+		app.LogIt.Info("ergänze Spalte expires_at in pools")
+		if _, err := database.Exec(`ALTER TABLE pools ADD COLUMN expires_at TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	hasColumn, err = columnExists(database, "users", "role")
+	if err != nil {
+		return err
+	}
+	if !hasColumn {
+		// bisherige Benutzer hatten vollen Zugriff und bleiben Administratoren
+		app.LogIt.Info("ergänze Spalten role und pools in users")
+		if _, err := database.Exec(`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'admin'`); err != nil {
+			return err
+		}
+		if _, err := database.Exec(`ALTER TABLE users ADD COLUMN pools TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+	}
+	hasColumn, err = columnExists(database, "users", "source")
+	if err != nil {
+		return err
+	}
+	if !hasColumn {
+		app.LogIt.Info("ergänze Spalte source in users")
+		if _, err := database.Exec(`ALTER TABLE users ADD COLUMN source TEXT NOT NULL DEFAULT 'local'`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func columnExists(database *sql.DB, table, column string) (bool, error) {
+	rows, err := database.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 func InsertEntry(dbConn *sql.DB, cidrString, name, comment, status string) (*PoolEntry, error) {
+	if err := helpers.CheckPoolName(name); err != nil {
+		return nil, err
+	}
 	if len(comment) > 60 {
 		comment = comment[:60]
 	}
@@ -90,6 +252,9 @@ func InsertEntry(dbConn *sql.DB, cidrString, name, comment, status string) (*Poo
 }
 
 func InsertPoollistEntry(dbConn *sql.DB, cidrString, name, comment, status string) error {
+	if err := helpers.CheckPoolName(name); err != nil {
+		return err
+	}
 	if len(comment) > 60 {
 		comment = comment[:60]
 	}
@@ -108,17 +273,76 @@ func InsertPoollistEntry(dbConn *sql.DB, cidrString, name, comment, status strin
 	return err
 }
 
+// InsertRangeEntry legt die CIDRs eines IP-Bereichs mit gemeinsamem Kommentar
+// und gemeinsamer Gruppen-ID an. Überschneidet sich einer der CIDRs mit einem
+// bestehenden Eintrag, wird dieser zurückgegeben und nichts angelegt.
+func InsertRangeEntry(dbConn *sql.DB, cidrs []string, name, comment, status string) (*PoolEntry, error) {
+	if err := helpers.CheckPoolName(name); err != nil {
+		return nil, err
+	}
+	for _, cidrString := range cidrs {
+		startIP, endIP, err := helpers.GetIPRange(cidrString)
+		if err != nil {
+			return nil, fmt.Errorf("ungültiger CIDR %s: %w", cidrString, err)
+		}
+		// auch kleinere Einträge innerhalb des CIDR zählen
+		found, err := FindOverlapping(dbConn, startIP, endIP)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) > 0 {
+			return &found[0], nil
+		}
+	}
+	return nil, InsertPoollistRange(dbConn, cidrs, name, comment, status)
+}
+
+// InsertPoollistRange legt die CIDRs eines IP-Bereichs ohne Prüfung auf
+// bestehende Einträge in einer Transaktion an.
+func InsertPoollistRange(dbConn *sql.DB, cidrs []string, name, comment, status string) error {
+	if err := helpers.CheckPoolName(name); err != nil {
+		return err
+	}
+	if len(comment) > 60 {
+		comment = comment[:60]
+	}
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var groupID int64
+	if err := tx.QueryRow(`SELECT IFNULL(MAX(group_id), 0) + 1 FROM pools`).Scan(&groupID); err != nil {
+		return err
+	}
+	for _, cidrString := range cidrs {
+		startIP, endIP, err := helpers.GetIPRange(cidrString)
+		if err != nil {
+			return fmt.Errorf("ungültiger CIDR %s: %w", cidrString, err)
+		}
+		_, err = tx.Exec(
+			"INSERT INTO pools(start_ip_int, end_ip_int, cidr, name, comment, status, group_id) VALUES(?, ?, ?, ?, ?, ?, ?)",
+			startIP, endIP, cidrString, name, comment, status, groupID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func FindPoolByIP(dbConn *sql.DB, ipUint uint32) (*PoolEntry, error) {
 	row := dbConn.QueryRow(`
-        SELECT id, start_ip_int, end_ip_int, cidr, name, comment, status
+        SELECT `+entryColumns+`
         FROM pools
         WHERE ? BETWEEN start_ip_int AND end_ip_int
         ORDER BY end_ip_int - start_ip_int ASC
         LIMIT 1
     `, ipUint)
 
-	p := &PoolEntry{}
-	if err := row.Scan(&p.ID, &p.StartIPInt, &p.EndIPInt, &p.CIDR, &p.Name, &p.Comment, &p.Status); err != nil {
+	p, err := scanEntry(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -129,7 +353,7 @@ func FindPoolByIP(dbConn *sql.DB, ipUint uint32) (*PoolEntry, error) {
 
 func FindBlacklistByIP(dbConn *sql.DB, ipUint uint32) (*PoolEntry, error) {
 	row := dbConn.QueryRow(`
-        SELECT id, start_ip_int, end_ip_int, cidr, name, comment, status
+        SELECT `+entryColumns+`
         FROM pools
         WHERE status = "b"
         AND ? BETWEEN start_ip_int AND end_ip_int
@@ -137,8 +361,8 @@ func FindBlacklistByIP(dbConn *sql.DB, ipUint uint32) (*PoolEntry, error) {
         LIMIT 1
     `, ipUint)
 
-	p := &PoolEntry{}
-	if err := row.Scan(&p.ID, &p.StartIPInt, &p.EndIPInt, &p.CIDR, &p.Name, &p.Comment, &p.Status); err != nil {
+	p, err := scanEntry(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -147,9 +371,34 @@ func FindBlacklistByIP(dbConn *sql.DB, ipUint uint32) (*PoolEntry, error) {
 	return p, nil
 }
 
+// FindOverlapping liefert alle Einträge, die den Bereich von start bis end
+// berühren, den spezifischsten zuerst
+func FindOverlapping(dbConn *sql.DB, start, end uint32) ([]PoolEntry, error) {
+	rows, err := dbConn.Query(`
+        SELECT `+entryColumns+`
+        FROM pools
+        WHERE start_ip_int <= ? AND end_ip_int >= ?
+        ORDER BY end_ip_int - start_ip_int ASC, name, start_ip_int
+    `, end, start)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []PoolEntry
+	for rows.Next() {
+		p, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *p)
+	}
+	return res, rows.Err()
+}
+
 func ListByPool(dbConn *sql.DB, poolName string) ([]PoolEntry, error) {
 	rows, err := dbConn.Query(`
-        SELECT id, start_ip_int, end_ip_int, cidr, name, comment, status
+        SELECT `+entryColumns+`
         FROM pools
         WHERE name = ?
         ORDER BY status, start_ip_int
@@ -161,15 +410,217 @@ func ListByPool(dbConn *sql.DB, poolName string) ([]PoolEntry, error) {
 
 	var res []PoolEntry
 	for rows.Next() {
-		var p PoolEntry
-		if err := rows.Scan(&p.ID, &p.StartIPInt, &p.EndIPInt, &p.CIDR, &p.Name, &p.Comment, &p.Status); err != nil {
+		p, err := scanEntry(rows)
+		if err != nil {
 			return nil, err
 		}
-		res = append(res, p)
+		res = append(res, *p)
 	}
 	return res, rows.Err()
 }
 
+// Alle Einträge aller Pools
+func ListEntries(dbConn *sql.DB) ([]PoolEntry, error) {
+	rows, err := dbConn.Query(`
+        SELECT ` + entryColumns + `
+        FROM pools
+        ORDER BY name, status, start_ip_int
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []PoolEntry
+	for rows.Next() {
+		p, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *p)
+	}
+	return res, rows.Err()
+}
+
+// Vergleich eines Eintrags mit dem gesuchten Bereich
+const (
+	MatchContains = "contains" // Eintrag enthält den Bereich
+	MatchWithin   = "within"   // Eintrag liegt im Bereich
+	MatchOverlaps = "overlaps" // Eintrag überschneidet den Bereich
+)
+
+// Sortierungen der Suche, immer gefolgt von Pool und Adresse
+var searchOrder = map[string]string{
+	"ip":      "start_ip_int",
+	"pool":    "name",
+	"status":  "status",
+	"comment": "comment",
+	"size":    "end_ip_int - start_ip_int",
+}
+
+// SearchFilter beschreibt eine Suche über alle Pools. Leere Felder schränken
+// nicht ein, Pool ist ein GLOB-Muster wie "kunde*".
+type SearchFilter struct {
+	HasRange bool
+	Start    uint32
+	End      uint32
+	Match    string
+	Comment  string
+	Pool     string
+	Pools    []string // nur diese Pools, z.B. für beschränkte Tokens
+	Status   string
+	Sort     string
+	Desc     bool
+	Limit    int
+	Offset   int
+}
+
+// SearchEntries liefert eine Seite der Einträge, die zum Filter passen, und
+// die Anzahl aller Treffer
+func SearchEntries(dbConn *sql.DB, f SearchFilter) ([]PoolEntry, int, error) {
+	var where []string
+	var args []any
+	if f.HasRange {
+		switch f.Match {
+		case MatchWithin:
+			where = append(where, "start_ip_int >= ? AND end_ip_int <= ?")
+			args = append(args, f.Start, f.End)
+		case MatchOverlaps:
+			where = append(where, "start_ip_int <= ? AND end_ip_int >= ?")
+			args = append(args, f.End, f.Start)
+		default:
+			where = append(where, "start_ip_int <= ? AND end_ip_int >= ?")
+			args = append(args, f.Start, f.End)
+		}
+	}
+	if f.Comment != "" {
+		where = append(where, "instr(lower(comment), lower(?)) > 0")
+		args = append(args, f.Comment)
+	}
+	if f.Pool != "" {
+		where = append(where, "name GLOB ?")
+		args = append(args, f.Pool)
+	}
+	if len(f.Pools) > 0 {
+		where = append(where, "name IN (?"+strings.Repeat(", ?", len(f.Pools)-1)+")")
+		for _, p := range f.Pools {
+			args = append(args, p)
+		}
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := dbConn.QueryRow(`SELECT COUNT(*) FROM pools `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	order, ok := searchOrder[f.Sort]
+	if !ok {
+		order = searchOrder["ip"]
+	}
+	if f.Desc {
+		order += " DESC"
+	}
+	rows, err := dbConn.Query(`
+        SELECT `+entryColumns+`
+        FROM pools
+        `+cond+`
+        ORDER BY `+order+`, name, start_ip_int
+        LIMIT ? OFFSET ?
+    `, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var res []PoolEntry
+	for rows.Next() {
+		p, err := scanEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, *p)
+	}
+	return res, total, rows.Err()
+}
+
+// ApplyChanges legt inserts an, löscht deletes und übernimmt Status und
+// Kommentar von updates - alles in einer Transaktion
+func ApplyChanges(dbConn *sql.DB, inserts, deletes, updates []PoolEntry) error {
+	if err := checkPoolNames(inserts); err != nil {
+		return err
+	}
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range inserts {
+		_, err := tx.Exec(
+			"INSERT INTO pools(start_ip_int, end_ip_int, cidr, name, comment, status) VALUES(?, ?, ?, ?, ?, ?)",
+			e.StartIPInt, e.EndIPInt, e.CIDR, e.Name, e.Comment, e.Status,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range deletes {
+		if _, err := tx.Exec(`DELETE FROM pools WHERE id = ?`, e.ID); err != nil {
+			return err
+		}
+	}
+	for _, e := range updates {
+		if _, err := tx.Exec(`UPDATE pools SET status = ?, comment = ? WHERE id = ?`, e.Status, e.Comment, e.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReplaceEntries ersetzt alle Einträge aller Pools in einer Transaktion durch
+// entries
+func ReplaceEntries(dbConn *sql.DB, entries []PoolEntry) error {
+	if err := checkPoolNames(entries); err != nil {
+		return err
+	}
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM pools`); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		_, err := tx.Exec(
+			"INSERT INTO pools(start_ip_int, end_ip_int, cidr, name, comment, status, group_id) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, 0))",
+			e.StartIPInt, e.EndIPInt, e.CIDR, e.Name, e.Comment, e.Status, e.GroupID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkPoolNames prüft die Poolnamen neuer Einträge
+func checkPoolNames(entries []PoolEntry) error {
+	for _, e := range entries {
+		if err := helpers.CheckPoolName(e.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Alle unterschiedlichen Pool-Namen
 func ListPoolNames(dbConn *sql.DB) ([]string, error) {
 	rows, err := dbConn.Query(`SELECT DISTINCT name FROM pools ORDER BY name`)
@@ -189,6 +640,42 @@ func ListPoolNames(dbConn *sql.DB) ([]string, error) {
 	return names, rows.Err()
 }
 
+// Alle Pools, die Einträge mit dem angegebenen Status enthalten
+func ListPoolNamesByStatus(dbConn *sql.DB, status string) ([]string, error) {
+	rows, err := dbConn.Query(`SELECT DISTINCT name FROM pools WHERE status = ? ORDER BY name`, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+	}
+	return names, rows.Err()
+}
+
+// Einen Eintrag anhand seiner ID, nil wenn es ihn nicht gibt
+func GetEntry(dbConn *sql.DB, entryID string) (*PoolEntry, error) {
+	p, err := scanEntry(dbConn.QueryRow(`SELECT `+entryColumns+` FROM pools WHERE id = ?`, entryID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// CountOutsidePool zählt die Einträge mit entryID oder groupID, die nicht zu
+// poolName gehören
+func CountOutsidePool(dbConn *sql.DB, poolName, entryID, groupID string) (int, error) {
+	var n int
+	err := dbConn.QueryRow(`SELECT COUNT(*) FROM pools WHERE (id = ? OR group_id = ?) AND name != ?`, entryID, groupID, poolName).Scan(&n)
+	return n, err
+}
+
 func WhitelistByID(dbConn *sql.DB, entryID string) error {
 	_, err := dbConn.Exec(`UPDATE pools SET status = "w" WHERE id = ?`, entryID)
 	return err
@@ -204,7 +691,127 @@ func DeleteByID(dbConn *sql.DB, entryID string) error {
 	return err
 }
 
-// Einen Pool whitelisten
+// Alle CIDRs eines importierten Bereichs whitelisten
+func WhitelistByGroup(dbConn *sql.DB, groupID string) error {
+	_, err := dbConn.Exec(`UPDATE pools SET status = "w" WHERE group_id = ?`, groupID)
+	return err
+}
+
+// Alle CIDRs eines importierten Bereichs blocken
+func BlockByGroup(dbConn *sql.DB, groupID string) error {
+	_, err := dbConn.Exec(`UPDATE pools SET status = "b" WHERE group_id = ?`, groupID)
+	return err
+}
+
+// Alle CIDRs eines importierten Bereichs löschen
+func DeleteByGroup(dbConn *sql.DB, groupID string) error {
+	_, err := dbConn.Exec(`DELETE FROM pools WHERE group_id = ?`, groupID)
+	return err
+}
+
+// Anzahl IDs je Anweisung, damit lange Auswahlen unter der Grenze von SQLite
+// für Parameter bleiben
+const bulkChunk = 500
+
+// BulkStatus setzt den Status der Einträge ids aus poolName in einer
+// Transaktion und liefert die Anzahl geänderter Einträge. Gehört ein Eintrag
+// zu einem Bereich, wird der ganze Bereich geändert.
+func BulkStatus(dbConn *sql.DB, poolName string, ids []int, status string) (int, error) {
+	return bulkExec(dbConn, poolName, ids, `UPDATE pools SET status = ? WHERE status IS NOT ?`, status, status)
+}
+
+// BulkMove verschiebt die Einträge ids samt ihren Bereichen aus poolName nach
+// target
+func BulkMove(dbConn *sql.DB, poolName string, ids []int, target string) (int, error) {
+	if err := helpers.CheckPoolName(target); err != nil {
+		return 0, err
+	}
+	return bulkExec(dbConn, poolName, ids, `UPDATE pools SET name = ? WHERE name IS NOT ?`, target, target)
+}
+
+// BulkExpire setzt das Ablaufdatum der Einträge ids samt ihren Bereichen,
+// ein leeres expiresAt hebt es auf
+func BulkExpire(dbConn *sql.DB, poolName string, ids []int, expiresAt string) (int, error) {
+	return bulkExec(dbConn, poolName, ids, `UPDATE pools SET expires_at = ? WHERE expires_at IS NOT ?`, expiresAt, expiresAt)
+}
+
+// BulkDelete löscht die Einträge ids samt ihren Bereichen aus poolName
+func BulkDelete(dbConn *sql.DB, poolName string, ids []int) (int, error) {
+	return bulkExec(dbConn, poolName, ids, `DELETE FROM pools WHERE 1`)
+}
+
+// bulkExec sucht in einer Transaktion die Einträge ids aus poolName samt den
+// übrigen CIDRs ihrer Bereiche und führt stmt für sie aus. stmt endet mit
+// einer WHERE-Bedingung, die um die IDs ergänzt wird.
+func bulkExec(dbConn *sql.DB, poolName string, ids []int, stmt string, args ...any) (int, error) {
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var selected []any
+	seen := map[int]bool{}
+	for chunk := range slices.Chunk(ids, bulkChunk) {
+		in := "?" + strings.Repeat(", ?", len(chunk)-1)
+		qArgs := []any{poolName}
+		for _, id := range chunk {
+			qArgs = append(qArgs, id)
+		}
+		// dieselben Argumente für die Unterabfrage der Bereiche
+		qArgs = append(qArgs, qArgs...)
+		rows, err := tx.Query(`
+        SELECT id FROM pools
+        WHERE name = ? AND (id IN (`+in+`)
+            OR group_id IN (SELECT group_id FROM pools WHERE name = ? AND id IN (`+in+`)))
+    `, qArgs...)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				selected = append(selected, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	var changed int
+	for chunk := range slices.Chunk(selected, bulkChunk) {
+		res, err := tx.Exec(stmt+` AND id IN (?`+strings.Repeat(", ?", len(chunk)-1)+`)`, append(slices.Clone(args), chunk...)...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		changed += int(n)
+	}
+	return changed, tx.Commit()
+}
+
+// DeleteExpired löscht alle Einträge, deren Ablaufdatum vor today liegt
+func DeleteExpired(dbConn *sql.DB, today string) (int, error) {
+	res, err := dbConn.Exec(`DELETE FROM pools WHERE expires_at != '' AND expires_at < ?`, today)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Einen Pool whitelisten - eine bestehende Blockliste des Pools wird bei der
+// nächsten Aktivierung gesichert und entfernt
 func WhitelistPool(dbConn *sql.DB, poolName string) ([]PoolEntry, error) {
 	app.LogIt.Debug("whitelisting pool " + poolName)
 	var foundEntries []PoolEntry
@@ -231,19 +838,464 @@ func WhitelistPool(dbConn *sql.DB, poolName string) ([]PoolEntry, error) {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Update des pools %s: %v", poolName, err))
 		return nil, err
 	}
-	// TODO: hier noch eine eventuell existierende blocklist.conf sichern und löschen
 	return nil, err
 }
 
-// Einen Pool blocken
+// Einen Pool blocken - eine bestehende Whitelist des Pools wird bei der
+// nächsten Aktivierung gesichert und entfernt
 func BlockPool(dbConn *sql.DB, poolName string) error {
 	_, err := dbConn.Exec(`UPDATE pools SET status = "b" WHERE name = ?`, poolName)
-	// TODO: hier noch eine eventuell existierende whitelist.conf sichern und löschen
 	return err
 }
 
-// Einen Pool löschen
+// Einen Pool löschen - seine Listen werden bei der nächsten Aktivierung
+// gesichert und entfernt
 func DeletePool(dbConn *sql.DB, poolName string) error {
 	_, err := dbConn.Exec(`DELETE FROM pools WHERE name = ?`, poolName)
 	return err
 }
+
+// ListFile ist eine von blv in den ListPath geschriebene Datei
+type ListFile struct {
+	Path      string
+	Checksum  string
+	Content   string
+	WrittenAt string
+}
+
+// Prüfsumme und Inhalt einer geschriebenen Datei festhalten
+func SaveListFile(dbConn *sql.DB, path, checksum, content string) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO list_files(path, checksum, content, written_at) VALUES(?, ?, ?, datetime('now'))
+        ON CONFLICT(path) DO UPDATE SET checksum = excluded.checksum, content = excluded.content, written_at = excluded.written_at
+    `, path, checksum, content)
+	return err
+}
+
+func DeleteListFile(dbConn *sql.DB, path string) error {
+	_, err := dbConn.Exec(`DELETE FROM list_files WHERE path = ?`, path)
+	return err
+}
+
+// Alle von blv geschriebenen Dateien
+func ListListFiles(dbConn *sql.DB) ([]ListFile, error) {
+	rows, err := dbConn.Query(`SELECT path, checksum, content, written_at FROM list_files ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []ListFile
+	for rows.Next() {
+		var f ListFile
+		if err := rows.Scan(&f.Path, &f.Checksum, &f.Content, &f.WrittenAt); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// Alle von blv geschriebenen Exporte
+func ListExportFiles(dbConn *sql.DB) ([]string, error) {
+	rows, err := dbConn.Query(`SELECT path FROM export_files ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
+
+// Die festgehaltenen Exporte durch paths ersetzen
+func ReplaceExportFiles(dbConn *sql.DB, paths []string) error {
+	tx, err := dbConn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM export_files`); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if _, err := tx.Exec(`INSERT INTO export_files(path, written_at) VALUES(?, datetime('now'))`, p); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ModSecRuleID ist die vergebene Regel-ID eines Pools mit Status, als Abstand
+// zu modSecurity.idBase
+type ModSecRuleID struct {
+	Pool   string
+	Status string
+	Offset int
+}
+
+// Alle vergebenen ModSecurity-Regel-IDs, auch die von gelöschten Pools
+func ListModSecRuleIDs(dbConn *sql.DB) ([]ModSecRuleID, error) {
+	rows, err := dbConn.Query(`SELECT pool, status, id_offset FROM modsec_rule_ids ORDER BY id_offset`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []ModSecRuleID
+	for rows.Next() {
+		var id ModSecRuleID
+		if err := rows.Scan(&id.Pool, &id.Status, &id.Offset); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Eine neue ModSecurity-Regel-ID festhalten, eine bereits vergebene ID ist ein Fehler
+func InsertModSecRuleID(dbConn *sql.DB, id ModSecRuleID) error {
+	_, err := dbConn.Exec(`INSERT INTO modsec_rule_ids(pool, status, id_offset) VALUES(?, ?, ?)`, id.Pool, id.Status, id.Offset)
+	return err
+}
+
+// Alle Einträge eines Pools mit dem angegebenen Status löschen
+func DeletePoolStatus(dbConn *sql.DB, poolName, status string) error {
+	_, err := dbConn.Exec(`DELETE FROM pools WHERE name = ? AND status = ?`, poolName, status)
+	return err
+}
+
+// FeedState ist der Stand des letzten Abrufs eines Feeds
+type FeedState struct {
+	Name         string
+	ETag         string
+	LastModified string
+	LastFetch    string
+	LastSuccess  string
+	LastError    string
+	Entries      int
+}
+
+// FeedHistory ist ein einzelner Abruf eines Feeds
+type FeedHistory struct {
+	ID         int
+	Name       string
+	FetchedAt  string
+	HTTPStatus int
+	Result     string // aktualisiert, unverändert, fehler
+	Added      int
+	Removed    int
+	Changed    int
+	Message    string
+}
+
+// Stand eines Feeds, ein leerer Stand, wenn er noch nie abgerufen wurde
+func GetFeedState(dbConn *sql.DB, name string) (*FeedState, error) {
+	st := &FeedState{Name: name}
+	err := dbConn.QueryRow(`
+        SELECT etag, last_modified, last_fetch, last_success, last_error, entries
+        FROM feeds WHERE name = ?
+    `, name).Scan(&st.ETag, &st.LastModified, &st.LastFetch, &st.LastSuccess, &st.LastError, &st.Entries)
+	if err == sql.ErrNoRows {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func SaveFeedState(dbConn *sql.DB, st *FeedState) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO feeds(name, etag, last_modified, last_fetch, last_success, last_error, entries) VALUES(?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(name) DO UPDATE SET etag = excluded.etag, last_modified = excluded.last_modified,
+            last_fetch = excluded.last_fetch, last_success = excluded.last_success,
+            last_error = excluded.last_error, entries = excluded.entries
+    `, st.Name, st.ETag, st.LastModified, st.LastFetch, st.LastSuccess, st.LastError, st.Entries)
+	return err
+}
+
+// Abruf festhalten und nur die letzten keep Abrufe des Feeds behalten
+func AddFeedHistory(dbConn *sql.DB, h *FeedHistory, keep int) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO feed_history(name, fetched_at, http_status, result, added, removed, changed, message)
+        VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+    `, h.Name, h.FetchedAt, h.HTTPStatus, h.Result, h.Added, h.Removed, h.Changed, h.Message)
+	if err != nil {
+		return err
+	}
+	_, err = dbConn.Exec(`
+        DELETE FROM feed_history WHERE name = ? AND id NOT IN (
+            SELECT id FROM feed_history WHERE name = ? ORDER BY id DESC LIMIT ?
+        )
+    `, h.Name, h.Name, keep)
+	return err
+}
+
+// Die letzten limit Abrufe eines Feeds, neueste zuerst
+func ListFeedHistory(dbConn *sql.DB, name string, limit int) ([]FeedHistory, error) {
+	rows, err := dbConn.Query(`
+        SELECT id, name, fetched_at, http_status, result, added, removed, changed, message
+        FROM feed_history WHERE name = ? ORDER BY id DESC LIMIT ?
+    `, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []FeedHistory
+	for rows.Next() {
+		var h FeedHistory
+		if err := rows.Scan(&h.ID, &h.Name, &h.FetchedAt, &h.HTTPStatus, &h.Result, &h.Added, &h.Removed, &h.Changed, &h.Message); err != nil {
+			return nil, err
+		}
+		res = append(res, h)
+	}
+	return res, rows.Err()
+}
+
+// APIToken ist ein Token für Skripte und andere Dienste. Gespeichert wird nur
+// der Hash. Ohne Pools gilt das Token für alle Pools.
+type APIToken struct {
+	ID        int
+	Name      string
+	Hash      string
+	Scopes    []string
+	Pools     []string
+	ExpiresAt string // YYYY-MM-DD, leer für unbegrenzt
+	LastUsed  string
+	CreatedAt string
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+func (t *APIToken) AllowsPool(pool string) bool {
+	return len(t.Pools) == 0 || slices.Contains(t.Pools, pool)
+}
+
+const tokenColumns = "id, name, hash, scopes, pools, expires_at, last_used, created_at"
+
+func scanToken(row rowScanner) (*APIToken, error) {
+	var t APIToken
+	var scopes, pools string
+	if err := row.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &pools, &t.ExpiresAt, &t.LastUsed, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = splitList(scopes)
+	t.Pools = splitList(pools)
+	return &t, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func CreateAPIToken(dbConn *sql.DB, t *APIToken) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO api_tokens(name, hash, scopes, pools, expires_at, created_at) VALUES(?, ?, ?, ?, ?, ?)
+    `, t.Name, t.Hash, strings.Join(t.Scopes, ","), strings.Join(t.Pools, ","), t.ExpiresAt, t.CreatedAt)
+	return err
+}
+
+// Token anhand seines Hashes, nil wenn es ihn nicht gibt
+func FindAPITokenByHash(dbConn *sql.DB, hash string) (*APIToken, error) {
+	t, err := scanToken(dbConn.QueryRow(`SELECT `+tokenColumns+` FROM api_tokens WHERE hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func ListAPITokens(dbConn *sql.DB) ([]APIToken, error) {
+	rows, err := dbConn.Query(`SELECT ` + tokenColumns + ` FROM api_tokens ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []APIToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *t)
+	}
+	return res, rows.Err()
+}
+
+func DeleteAPIToken(dbConn *sql.DB, id string) error {
+	_, err := dbConn.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
+
+func TouchAPIToken(dbConn *sql.DB, id int, usedAt string) error {
+	_, err := dbConn.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, usedAt, id)
+	return err
+}
+
+// User ist ein Benutzer des Admin-Bereichs. Gespeichert wird nur der
+// bcrypt-Hash des Passworts. Ohne Pools gilt die Rolle für alle Pools.
+// Benutzer aus einem Verzeichnis oder Identity Provider (Source ldap, oidc)
+// haben kein Passwort, ihre Rolle wird bei jeder Anmeldung neu bestimmt.
+type User struct {
+	Username     string
+	PasswordHash string
+	Role         string
+	Pools        []string
+	Source       string // local, ldap, oidc
+	LastLogin    string
+	CreatedAt    string
+}
+
+const userColumns = "username, password_hash, role, pools, source, last_login, created_at"
+
+func scanUser(row rowScanner) (*User, error) {
+	var u User
+	var pools string
+	if err := row.Scan(&u.Username, &u.PasswordHash, &u.Role, &pools, &u.Source, &u.LastLogin, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	u.Pools = splitList(pools)
+	return &u, nil
+}
+
+func CreateUser(dbConn *sql.DB, u *User) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO users(username, password_hash, role, pools, source, created_at) VALUES(?, ?, ?, ?, ?, ?)
+    `, u.Username, u.PasswordHash, u.Role, strings.Join(u.Pools, ","), u.Source, u.CreatedAt)
+	return err
+}
+
+// SaveExternalUser legt einen Benutzer aus einem Verzeichnis an oder
+// aktualisiert Rolle, Pools und letzte Anmeldung. Lokale Benutzer mit
+// gleichem Namen bleiben unverändert.
+func SaveExternalUser(dbConn *sql.DB, u *User) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO users(username, password_hash, role, pools, source, last_login, created_at) VALUES(?, '', ?, ?, ?, ?, ?)
+        ON CONFLICT(username) DO UPDATE SET role = excluded.role, pools = excluded.pools, last_login = excluded.last_login
+        WHERE users.source = excluded.source
+    `, u.Username, u.Role, strings.Join(u.Pools, ","), u.Source, u.LastLogin, u.CreatedAt)
+	return err
+}
+
+// Benutzer anhand seines Namens, nil wenn es ihn nicht gibt
+func GetUser(dbConn *sql.DB, username string) (*User, error) {
+	u, err := scanUser(dbConn.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+func ListUsers(dbConn *sql.DB) ([]User, error) {
+	rows, err := dbConn.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *u)
+	}
+	return res, rows.Err()
+}
+
+// SetUserPassword meldet false, wenn es den Benutzer nicht gibt
+func SetUserPassword(dbConn *sql.DB, username, hash string) (bool, error) {
+	res, err := dbConn.Exec(`UPDATE users SET password_hash = ? WHERE username = ?`, hash, username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetUserRole meldet false, wenn es den Benutzer nicht gibt
+func SetUserRole(dbConn *sql.DB, username, role string, pools []string) (bool, error) {
+	res, err := dbConn.Exec(`UPDATE users SET role = ?, pools = ? WHERE username = ?`, role, strings.Join(pools, ","), username)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func DeleteUser(dbConn *sql.DB, username string) error {
+	_, err := dbConn.Exec(`DELETE FROM users WHERE username = ?`, username)
+	return err
+}
+
+func TouchUser(dbConn *sql.DB, username, loginAt string) error {
+	_, err := dbConn.Exec(`UPDATE users SET last_login = ? WHERE username = ?`, loginAt, username)
+	return err
+}
+
+// Session ist eine Anmeldung im Admin-Bereich. Gespeichert wird nur der Hash
+// der Session-ID aus dem Cookie.
+type Session struct {
+	Hash      string
+	Username  string
+	CSRF      string
+	CreatedAt string
+	LastSeen  string
+}
+
+func CreateSession(dbConn *sql.DB, s *Session) error {
+	_, err := dbConn.Exec(`
+        INSERT INTO sessions(hash, username, csrf, created_at, last_seen) VALUES(?, ?, ?, ?, ?)
+    `, s.Hash, s.Username, s.CSRF, s.CreatedAt, s.LastSeen)
+	return err
+}
+
+// Session anhand ihres Hashes, nil wenn es sie nicht gibt
+func GetSession(dbConn *sql.DB, hash string) (*Session, error) {
+	var s Session
+	err := dbConn.QueryRow(`SELECT hash, username, csrf, created_at, last_seen FROM sessions WHERE hash = ?`, hash).
+		Scan(&s.Hash, &s.Username, &s.CSRF, &s.CreatedAt, &s.LastSeen)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &s, err
+}
+
+func TouchSession(dbConn *sql.DB, hash, lastSeen string) error {
+	_, err := dbConn.Exec(`UPDATE sessions SET last_seen = ? WHERE hash = ?`, lastSeen, hash)
+	return err
+}
+
+func DeleteSession(dbConn *sql.DB, hash string) error {
+	_, err := dbConn.Exec(`DELETE FROM sessions WHERE hash = ?`, hash)
+	return err
+}
+
+// DeleteUserSessions meldet einen Benutzer überall ab
+func DeleteUserSessions(dbConn *sql.DB, username string) error {
+	_, err := dbConn.Exec(`DELETE FROM sessions WHERE username = ?`, username)
+	return err
+}
+
+// DeleteExpiredSessions löscht Sessions, die seit idleBefore nicht mehr
+// verwendet oder vor createdBefore angelegt wurden
+func DeleteExpiredSessions(dbConn *sql.DB, idleBefore, createdBefore string) error {
+	_, err := dbConn.Exec(`DELETE FROM sessions WHERE last_seen < ? OR created_at < ?`, idleBefore, createdBefore)
+	return err
+}
@@ -0,0 +1,348 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+const (
+	stagingDir  = ".staging"
+	previousDir = ".previous"
+)
+
+// activationMu sorgt dafür, dass immer nur eine Aktivierung oder
+// Wiederherstellung die Listen, .staging und .previous verändert
+var activationMu sync.Mutex
+
+// ActivationResult beschreibt den Verlauf einer Aktivierung für die Anzeige
+type ActivationResult struct {
+	Files          []string `json:"files"`
+	Removed        []string `json:"removed"`
+	Exported       []string `json:"exported"`
+	ExportsRemoved []string `json:"exportsRemoved"`
+	Command        string   `json:"command"`
+	Output         string   `json:"output"`
+	RolledBack     bool     `json:"rolledBack"`
+	RollbackOutput string   `json:"rollbackOutput,omitempty"`
+}
+
+// ExportDB2Conf schreibt die Listen zuerst in ein Staging-Verzeichnis im
+// ListPath, prüft sie und tauscht sie dann Datei für Datei per Rename aus.
+// Verwaiste Listen von gelöschten Pools oder Pools mit geändertem Status
+// werden dabei entfernt. Nach erfolgreichem Reload werden die zusätzlichen
+// Exporte (exportTemplates, ModSecurity, AWS WAF, Cloudflare) geschrieben.
+// Anschliessend wird das reloadCommand ausgeführt. Schlägt es fehl, werden
+// die vorherigen Dateien wiederhergestellt und der Webserver erneut geladen.
+func ExportDB2Conf(database *sql.DB) (*ActivationResult, error) {
+	return activate(database, "")
+}
+
+// ActivatePreviewed aktiviert wie ExportDB2Conf, aber nur, wenn die Änderungen
+// noch der Vorschau mit fingerprint entsprechen. Sonst wird nichts geschrieben
+// und ErrPreviewOutdated geliefert.
+func ActivatePreviewed(database *sql.DB, fingerprint string) (*ActivationResult, error) {
+	if fingerprint == "" {
+		return &ActivationResult{Command: app.Config.ReloadCommand}, ErrPreviewOutdated
+	}
+	return activate(database, fingerprint)
+}
+
+func activate(database *sql.DB, fingerprint string) (*ActivationResult, error) {
+	activationMu.Lock()
+	defer activationMu.Unlock()
+	result := &ActivationResult{Command: app.Config.ReloadCommand}
+
+	staging := app.Config.ListPath + stagingDir + "/"
+	defer os.RemoveAll(staging)
+	if err := StageDB(database, staging); err != nil {
+		app.LogIt.Error(fmt.Sprintf("Fehler beim Export der Datenbank: %v", err))
+		return result, err
+	}
+	files, err := ValidateStaging(staging)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Keine Dateien aktiviert, die Prüfung ist fehlgeschlagen: %v", err))
+		return result, err
+	}
+	if fingerprint != "" {
+		diffs, err := diffStaging(staging, files)
+		if err != nil {
+			return result, err
+		}
+		if PreviewFingerprint(diffs) != fingerprint {
+			app.LogIt.Warn("Keine Dateien aktiviert, die Änderungen entsprechen nicht mehr der Vorschau")
+			return result, ErrPreviewOutdated
+		}
+	}
+
+	// aktuelle Listen sichern:
+	if err := backupCurrentLists(); err != nil {
+		app.LogIt.Error("Keine Dateien Exportiert, weil kein Backup erstellt werden konnte")
+		return result, err
+	}
+	result.Files = files
+	removed, err := orphanedListFiles(files)
+	if err != nil {
+		return result, err
+	}
+	result.Removed = removed
+	exports, err := renderExports(database)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Keine Dateien aktiviert, die Exporte sind fehlerhaft: %v", err))
+		return result, err
+	}
+
+	if err := installStaging(staging, files, removed, result); err != nil {
+		return result, err
+	}
+	return result, finishActivation(database, result, exports)
+}
+
+// installStaging tauscht die geprüften Dateien files aus staging gegen die
+// Listen im ListPath, entfernt die Listen unter removed und führt das
+// reloadCommand aus. Schlägt der Austausch oder das reloadCommand fehl,
+// werden die vorherigen Dateien wiederhergestellt.
+func installStaging(staging string, files, removed []string, result *ActivationResult) error {
+	previous := app.Config.ListPath + previousDir + "/"
+	created, err := swapFiles(staging, previous, app.Config.ListPath, files, removed)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Fehler beim Austausch der Listen: %v", err))
+		if rbErr := rollbackFiles(previous, app.Config.ListPath, files, created, removed); rbErr != nil {
+			app.LogIt.Error(fmt.Sprintf("Fehler beim Wiederherstellen der Listen: %v", rbErr))
+		}
+		result.RolledBack = true
+		return err
+	}
+	fmt.Println("Listen in den listPath geschrieben.")
+	app.LogIt.Info("Listen in den listPath geschrieben.")
+
+	if app.Config.ReloadCommand == "" {
+		fmt.Println("der Webserver muss neu geladen werden (systemctl reload apache2)")
+		app.LogIt.Info("kein reloadCommand konfiguriert - der Webserver muss neu geladen werden")
+		return nil
+	}
+
+	output, err := runReloadCommand(app.Config.ReloadCommand)
+	result.Output = output
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("reloadCommand fehlgeschlagen, stelle die vorherigen Listen wieder her: %v\n%s", err, output))
+		if rbErr := rollbackFiles(previous, app.Config.ListPath, files, created, removed); rbErr != nil {
+			app.LogIt.Error(fmt.Sprintf("Fehler beim Wiederherstellen der Listen: %v", rbErr))
+			return errors.Join(err, rbErr)
+		}
+		result.RolledBack = true
+		result.RollbackOutput, _ = runReloadCommand(app.Config.ReloadCommand)
+		app.LogIt.Info("vorherige Listen wiederhergestellt")
+		return fmt.Errorf("reloadCommand fehlgeschlagen: %w", err)
+	}
+	app.LogIt.Info("Webserver neu geladen: " + output)
+	return nil
+}
+
+// finishActivation hält die aktivierten Listen fest und schreibt die
+// zusätzlichen Exporte
+func finishActivation(database *sql.DB, result *ActivationResult, exports []exportFile) error {
+	if err := recordActivatedFiles(database, result.Files, result.Removed); err != nil {
+		return err
+	}
+	written, removed, err := writeExportFiles(database, exports)
+	result.Exported, result.ExportsRemoved = written, removed
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Fehler beim Schreiben der Exporte: %v", err))
+		return err
+	}
+	if len(written) > 0 {
+		app.LogIt.Info(fmt.Sprintf("%d Exporte geschrieben", len(written)))
+	}
+	return nil
+}
+
+func recordActivatedFiles(database *sql.DB, files, removed []string) error {
+	for _, rel := range removed {
+		app.LogIt.Info(fmt.Sprintf("verwaiste Liste %s entfernt", rel))
+		if err := db.DeleteListFile(database, rel); err != nil {
+			app.LogIt.Error(fmt.Sprintf("Fehler beim Entfernen der Prüfsumme von %s: %v", rel, err))
+			return err
+		}
+	}
+	if err := RecordListFiles(database, files); err != nil {
+		app.LogIt.Error(fmt.Sprintf("Fehler beim Festhalten der Prüfsummen: %v", err))
+		return err
+	}
+	return nil
+}
+
+// StageDB schreibt alle Listen und die Include-Datei in das Verzeichnis
+// staging. Die Include-Datei verweist bereits auf die Listen im ListPath.
+func StageDB(database *sql.DB, staging string) error {
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	for _, dir := range []string{"whitelists", "blocklists"} {
+		if err := os.MkdirAll(staging+dir, 0o750); err != nil {
+			return err
+		}
+	}
+	if err := ExportDB(database, staging); err != nil {
+		return err
+	}
+	whitelists, blocklists, err := ExpectedListFiles(database)
+	if err != nil {
+		return err
+	}
+	return WriteMasterInclude(staging, app.Config.ListPath, whitelists, blocklists)
+}
+
+// ValidateStaging prüft, ob alle geschriebenen Listen wieder eingelesen werden
+// können und jede Include-Zeile auf eine geschriebene Datei zeigt. Geliefert
+// werden die Dateien relativ zum Staging-Verzeichnis.
+func ValidateStaging(staging string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(staging, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range files {
+		if rel == app.Config.MasterInclude {
+			continue
+		}
+		f, err := os.Open(filepath.Join(staging, rel))
+		if err != nil {
+			return nil, err
+		}
+		entries, unparsed, err := ParseConf(f, "")
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		if len(unparsed) > 0 {
+			return nil, fmt.Errorf("%s: nicht lesbare Einträge %v", rel, unparsed)
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("%s: enthält keine Einträge", rel)
+		}
+	}
+
+	included, err := os.ReadFile(filepath.Join(staging, app.Config.MasterInclude))
+	if err != nil {
+		return nil, err
+	}
+	absList, err := filepath.Abs(app.Config.ListPath)
+	if err != nil {
+		return nil, err
+	}
+	for line := range bytes.Lines(included) {
+		fields := bytes.Fields(line)
+		if len(fields) != 2 || string(fields[0]) != "Include" {
+			continue
+		}
+		rel, err := filepath.Rel(absList, string(fields[1]))
+		if err != nil || !helpers.StringInSlice(rel, files) {
+			return nil, fmt.Errorf("%s bindet %s ein, die Datei wurde aber nicht geschrieben", app.Config.MasterInclude, fields[1])
+		}
+	}
+	return files, nil
+}
+
+// swapFiles sichert die bestehenden Dateien nach previous und ersetzt sie per
+// Rename durch die Dateien aus staging. Die Dateien unter removed werden nach
+// previous verschoben. Geliefert werden die Dateien, die es vorher nicht gab.
+func swapFiles(staging, previous, listPath string, files, removed []string) (created []string, err error) {
+	if err := os.RemoveAll(previous); err != nil {
+		return nil, err
+	}
+	for _, rel := range files {
+		target := filepath.Join(listPath, rel)
+		if helpers.FileExists(target) {
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(previous, rel)), 0o750); err != nil {
+				return created, err
+			}
+			if err := copyFile(target, filepath.Join(previous, rel)); err != nil {
+				return created, err
+			}
+		} else {
+			created = append(created, rel)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+			return created, err
+		}
+		if err := os.Rename(filepath.Join(staging, rel), target); err != nil {
+			return created, err
+		}
+	}
+	for _, rel := range removed {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(previous, rel)), 0o750); err != nil {
+			return created, err
+		}
+		if err := os.Rename(filepath.Join(listPath, rel), filepath.Join(previous, rel)); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// rollbackFiles stellt den Zustand vor swapFiles wieder her
+func rollbackFiles(previous, listPath string, files, created, removed []string) error {
+	var errs []error
+	for _, rel := range slices.Concat(files, removed) {
+		target := filepath.Join(listPath, rel)
+		if helpers.StringInSlice(rel, created) {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		backup := filepath.Join(previous, rel)
+		if !helpers.FileExists(backup) {
+			continue
+		}
+		if err := os.Rename(backup, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o644)
+}
+
+// runReloadCommand führt das reloadCommand in einer Shell aus, damit auch
+// Ketten wie "apachectl -t && apachectl graceful" möglich sind.
+func runReloadCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	return string(output), err
+}
@@ -0,0 +1,476 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+const (
+	// Name des Backups, das ResetDB im BackupPath anlegt
+	ResetBackupName = "reset"
+
+	listArchivePrefix = "lists-"
+	listArchiveSuffix = ".tar.gz"
+	dbBackupPrefix    = "db-"
+	dbBackupSuffix    = ".sqlite.gz"
+	backupTimeLayout  = "20060102-150405"
+)
+
+// Backup ist ein Satz gesicherter Listen: ein Archiv aus der Aktivierung, ein
+// datiertes Verzeichnis älterer Versionen oder der Export vor dem letzten Reset.
+type Backup struct {
+	Name    string
+	Kind    string // archiv, verzeichnis, reset
+	Files   []BackupFile
+	Entries int
+}
+
+type BackupFile struct {
+	Name    string
+	Pool    string
+	Status  string
+	Entries int
+	data    []byte
+}
+
+// DBBackup ist eine komprimierte Kopie der Datenbank
+type DBBackup struct {
+	Name string
+	Size int64
+}
+
+// ListBackups liefert alle vorhandenen Backups der Listen, das neueste zuerst
+func ListBackups() ([]Backup, error) {
+	var names []string
+
+	archives, err := listBackupFiles(listArchivePrefix, listArchiveSuffix)
+	if err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		names = append(names, strings.TrimSuffix(archive, listArchiveSuffix))
+	}
+
+	// datierte Verzeichnisse älterer Versionen
+	dirs := map[string]bool{}
+	for _, dir := range []string{"whitelists", "blocklists"} {
+		entries, err := os.ReadDir(app.Config.ListPath + dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs[entry.Name()] = true
+			}
+		}
+	}
+	var dated []string
+	for name := range dirs {
+		dated = append(dated, name)
+	}
+	slices.Sort(dated)
+	slices.Reverse(dated)
+	names = append(names, dated...)
+
+	var backups []Backup
+	for _, name := range names {
+		backup, err := GetBackup(name)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, *backup)
+	}
+	if reset, err := GetBackup(ResetBackupName); err == nil && len(reset.Files) > 0 {
+		backups = append(backups, *reset)
+	}
+	return backups, nil
+}
+
+// GetBackup liest ein einzelnes Backup samt Anzahl der Einträge je Datei
+func GetBackup(name string) (*Backup, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("ungültiger Name für ein Backup: %s", name)
+	}
+	backup := &Backup{Name: name}
+	files := map[string][]byte{}
+	var err error
+	switch {
+	case strings.HasPrefix(name, listArchivePrefix):
+		backup.Kind = "archiv"
+		files, err = helpers.ReadTarGz(app.Config.BackupPath + name + listArchiveSuffix)
+		if err != nil {
+			return nil, fmt.Errorf("Backup %s nicht lesbar: %w", name, err)
+		}
+	case name == ResetBackupName:
+		backup.Kind = "reset"
+		err = readConfDirs(files, app.Config.BackupPath+"whitelists/", app.Config.BackupPath+"blocklists/")
+	default:
+		backup.Kind = "verzeichnis"
+		err = readConfDirs(files, app.Config.ListPath+"whitelists/"+name+"/", app.Config.ListPath+"blocklists/"+name+"/")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rels []string
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	slices.Sort(rels)
+	for _, rel := range rels {
+		dir, file := filepath.Split(rel)
+		var status string
+		switch dir {
+		case "whitelists/":
+			status = "w"
+		case "blocklists/":
+			status = "b"
+		default:
+			// z.B. die Include-Datei
+			continue
+		}
+		parsed, _, err := ParseConf(bytes.NewReader(files[rel]), status)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rel, err)
+		}
+		backup.Files = append(backup.Files, BackupFile{
+			Name:    file,
+			Pool:    strings.TrimSuffix(file, ".conf"),
+			Status:  status,
+			Entries: len(parsed),
+			data:    files[rel],
+		})
+		backup.Entries += len(parsed)
+	}
+	return backup, nil
+}
+
+// readConfDirs liest alle .conf Dateien der beiden Verzeichnisse nach files
+func readConfDirs(files map[string][]byte, whitelistDir, blocklistDir string) error {
+	found := false
+	for _, dir := range []struct{ path, rel string }{{whitelistDir, "whitelists/"}, {blocklistDir, "blocklists/"}} {
+		entries, err := os.ReadDir(dir.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		found = true
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".conf" {
+				continue
+			}
+			data, err := os.ReadFile(dir.path + entry.Name())
+			if err != nil {
+				return err
+			}
+			files[dir.rel+entry.Name()] = data
+		}
+	}
+	if !found {
+		return fmt.Errorf("Backup nicht gefunden")
+	}
+	return nil
+}
+
+// RestoreBackupToFiles ersetzt die Listen im ListPath durch die Dateien des
+// Backups. Wie bei der Aktivierung werden die Dateien zuerst in .staging
+// geschrieben und geprüft, die aktuellen Listen vorher gesichert und Listen,
+// die es im Backup nicht gibt, entfernt. Schlägt das reloadCommand fehl,
+// werden die vorherigen Listen wiederhergestellt. Geliefert wird die Ausgabe
+// des reloadCommand.
+func RestoreBackupToFiles(database *sql.DB, name string) (string, error) {
+	activationMu.Lock()
+	defer activationMu.Unlock()
+	backup, err := GetBackup(name)
+	if err != nil {
+		return "", err
+	}
+
+	staging := app.Config.ListPath + stagingDir + "/"
+	defer os.RemoveAll(staging)
+	if err := os.RemoveAll(staging); err != nil {
+		return "", err
+	}
+	var whitelists, blocklists []string
+	for _, dir := range []string{"whitelists", "blocklists"} {
+		if err := os.MkdirAll(staging+dir, 0o750); err != nil {
+			return "", err
+		}
+	}
+	for _, f := range backup.Files {
+		dir := "blocklists/"
+		if f.Status == "w" {
+			dir = "whitelists/"
+			whitelists = append(whitelists, f.Pool)
+		} else {
+			blocklists = append(blocklists, f.Pool)
+		}
+		if err := os.WriteFile(staging+dir+f.Name, f.data, 0o644); err != nil {
+			return "", err
+		}
+	}
+	if err := WriteMasterInclude(staging, app.Config.ListPath, whitelists, blocklists); err != nil {
+		return "", err
+	}
+	files, err := ValidateStaging(staging)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Backup %s nicht wiederhergestellt, die Prüfung ist fehlgeschlagen: %v", name, err))
+		return "", err
+	}
+	removed, err := orphanedListFiles(files)
+	if err != nil {
+		return "", err
+	}
+	if err := backupCurrentLists(); err != nil {
+		app.LogIt.Error("Backup nicht wiederhergestellt, weil die aktuellen Listen nicht gesichert werden konnten")
+		return "", err
+	}
+
+	result := &ActivationResult{Command: app.Config.ReloadCommand}
+	if err := installStaging(staging, files, removed, result); err != nil {
+		return result.Output, err
+	}
+	if err := ReplaceListFileRecords(database, files); err != nil {
+		return result.Output, err
+	}
+	app.LogIt.Info(fmt.Sprintf("Backup %s in die Listen wiederhergestellt (%d Dateien, %d Einträge)", name, len(backup.Files), backup.Entries))
+	return result.Output, nil
+}
+
+// RestoreBackupToDB ersetzt den Inhalt der Datenbank durch die Einträge des
+// Backups. Die Datenbank wird vorher gesichert, die Einträge werden in einer
+// Transaktion ersetzt, damit ein Fehler keine halb gefüllte Datenbank hinterlässt.
+func RestoreBackupToDB(database *sql.DB, name string) error {
+	backup, err := GetBackup(name)
+	if err != nil {
+		return err
+	}
+	var entries []db.PoolEntry
+	var unparsed []string
+	for _, f := range backup.Files {
+		parsed, err := confToEntries(f.data, f.Pool, f.Status, &unparsed)
+		if err != nil {
+			app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen von %s: %v", f.Name, err))
+			return err
+		}
+		entries = append(entries, parsed...)
+	}
+	for _, u := range unparsed {
+		app.LogIt.Warn(fmt.Sprintf("Wiederherstellen von %s: %s nicht verstanden", name, u))
+	}
+
+	if _, err := BackupDB(database); err != nil {
+		app.LogIt.Error(fmt.Sprintf("Backup nicht wiederhergestellt, weil die Datenbank nicht gesichert werden konnte: %v", err))
+		return err
+	}
+	if err := db.MigrateTables(database); err != nil {
+		return err
+	}
+	if err := db.ReplaceEntries(database, entries); err != nil {
+		app.LogIt.Error(fmt.Sprintf("Backup %s nicht wiederhergestellt, die Datenbank ist unverändert: %v", name, err))
+		return err
+	}
+	app.LogIt.Info(fmt.Sprintf("Backup %s in die Datenbank wiederhergestellt (%d Dateien, %d Einträge)", name, len(backup.Files), len(entries)))
+	return nil
+}
+
+// backupCurrentLists sichert die aktuellen Listen und die Include-Datei in ein
+// komprimiertes Archiv mit Zeitstempel im BackupPath und räumt anschliessend
+// gemäss backupRetention auf.
+func backupCurrentLists() error {
+	files, err := managedListFiles()
+	if err != nil {
+		return err
+	}
+	if helpers.FileExists(app.Config.ListPath + app.Config.MasterInclude) {
+		files = append(files, app.Config.MasterInclude)
+	}
+
+	archive, err := newBackupPath(listArchivePrefix, listArchiveSuffix)
+	if err != nil {
+		return err
+	}
+	if err := helpers.WriteTarGz(archive, app.Config.ListPath, files); err != nil {
+		return err
+	}
+	fmt.Println(len(files), "Dateien von", app.Config.ListPath, "nach", archive, "gesichert")
+	app.LogIt.Info(fmt.Sprintf("%d Dateien nach %s gesichert", len(files), archive))
+	return pruneBackups(listArchivePrefix, listArchiveSuffix)
+}
+
+// BackupDB erstellt per VACUUM INTO eine konsistente Kopie der laufenden
+// Datenbank, komprimiert sie und räumt gemäss backupRetention auf.
+func BackupDB(database *sql.DB) (string, error) {
+	target, err := newBackupPath(dbBackupPrefix, dbBackupSuffix)
+	if err != nil {
+		return "", err
+	}
+	raw := strings.TrimSuffix(target, ".gz")
+	if _, err := database.Exec(`VACUUM INTO ?`, raw); err != nil {
+		return "", fmt.Errorf("Fehler beim Sichern der Datenbank: %w", err)
+	}
+	if err := helpers.GzipFile(raw, target); err != nil {
+		os.Remove(raw)
+		return "", fmt.Errorf("Fehler beim Komprimieren der Datenbank: %w", err)
+	}
+	app.LogIt.Info("Datenbank gesichert nach " + target)
+	return target, pruneBackups(dbBackupPrefix, dbBackupSuffix)
+}
+
+// StartDBBackups sichert die Datenbank im Abstand von interval im Hintergrund
+func StartDBBackups(database *sql.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	app.LogIt.Info(fmt.Sprintf("Datenbank wird alle %v gesichert", interval))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := BackupDB(database); err != nil {
+				app.LogIt.Error(fmt.Sprintf("geplante Sicherung der Datenbank fehlgeschlagen: %v", err))
+			}
+		}
+	}()
+}
+
+// ListDBBackups liefert die Sicherungen der Datenbank, die neueste zuerst
+func ListDBBackups() ([]DBBackup, error) {
+	names, err := listBackupFiles(dbBackupPrefix, dbBackupSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []DBBackup
+	for _, name := range names {
+		info, err := os.Stat(app.Config.BackupPath + name)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, DBBackup{Name: name, Size: info.Size()})
+	}
+	return backups, nil
+}
+
+// newBackupPath liefert einen noch nicht vorhandenen Dateinamen mit
+// Zeitstempel im BackupPath
+func newBackupPath(prefix, suffix string) (string, error) {
+	if err := os.MkdirAll(app.Config.BackupPath, 0o750); err != nil {
+		return "", err
+	}
+	stamp := time.Now().Format(backupTimeLayout)
+	path := app.Config.BackupPath + prefix + stamp + suffix
+	for i := 1; helpers.FileExists(path); i++ {
+		path = fmt.Sprintf("%s%s%s.%d%s", app.Config.BackupPath, prefix, stamp, i, suffix)
+	}
+	return path, nil
+}
+
+// listBackupFiles liefert die Backups mit prefix und suffix im BackupPath,
+// das neueste zuerst
+func listBackupFiles(prefix, suffix string) ([]string, error) {
+	entries, err := os.ReadDir(app.Config.BackupPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && strings.HasSuffix(entry.Name(), suffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if c := backupTime(b, prefix).Compare(backupTime(a, prefix)); c != 0 {
+			return c
+		}
+		return strings.Compare(b, a)
+	})
+	return names, nil
+}
+
+func backupTime(name, prefix string) time.Time {
+	stamp := strings.TrimPrefix(name, prefix)
+	if len(stamp) < len(backupTimeLayout) {
+		return time.Time{}
+	}
+	t, _ := time.ParseInLocation(backupTimeLayout, stamp[:len(backupTimeLayout)], time.Local)
+	return t
+}
+
+// pruneBackups löscht alle Backups mit prefix und suffix, die nach
+// backupRetention nicht mehr behalten werden
+func pruneBackups(prefix, suffix string) error {
+	names, err := listBackupFiles(prefix, suffix)
+	if err != nil {
+		return err
+	}
+	times := make([]time.Time, len(names))
+	for i, name := range names {
+		times[i] = backupTime(name, prefix)
+	}
+	keep := retainedBackups(times, app.Config.BackupRetention)
+	for i, name := range names {
+		if keep[i] {
+			continue
+		}
+		if err := os.Remove(app.Config.BackupPath + name); err != nil {
+			return err
+		}
+		app.LogIt.Info("Backup " + name + " gemäss backupRetention gelöscht")
+	}
+	return nil
+}
+
+// retainedBackups markiert die zu behaltenden Backups. times muss absteigend
+// sortiert sein.
+func retainedBackups(times []time.Time, r app.RetentionConfig) []bool {
+	keep := make([]bool, len(times))
+	if r.Last == 0 && r.Daily == 0 && r.Weekly == 0 && r.Monthly == 0 {
+		for i := range keep {
+			keep[i] = true
+		}
+		return keep
+	}
+	for i := 0; i < r.Last && i < len(times); i++ {
+		keep[i] = true
+	}
+	// jeweils das neueste Backup der letzten count Perioden behalten
+	keepPeriods := func(count int, period func(time.Time) string) {
+		seen := map[string]bool{}
+		for i, t := range times {
+			if len(seen) >= count {
+				return
+			}
+			p := period(t)
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			keep[i] = true
+		}
+	}
+	keepPeriods(r.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(r.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	keepPeriods(r.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+	return keep
+}
@@ -0,0 +1,109 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// Sammelaktionen für ausgewählte Einträge eines Pools
+const (
+	BulkBlock     = "block"
+	BulkWhitelist = "whitelist"
+	BulkDelete    = "delete"
+	BulkMove      = "move"
+	BulkExpire    = "expire"
+)
+
+// BulkActions sind alle Sammelaktionen
+var BulkActions = []string{BulkBlock, BulkWhitelist, BulkDelete, BulkMove, BulkExpire}
+
+// expiryCheckPeriod ist der Abstand, in dem abgelaufene Einträge gelöscht werden
+const expiryCheckPeriod = time.Hour
+
+// BulkRequest ist eine Sammelaktion für die Einträge IDs aus Pool. Target ist
+// der Zielpool beim Verschieben, ExpiresAt das Ablaufdatum (JJJJ-MM-TT), leer
+// hebt es auf.
+type BulkRequest struct {
+	Pool      string
+	Action    string
+	IDs       []string
+	Target    string
+	ExpiresAt string
+}
+
+// BulkEntries prüft eine Sammelaktion und führt sie in einer Transaktion aus.
+// Ausgewählte Bereiche werden immer ganz geändert. Geliefert wird die Anzahl
+// geänderter Einträge.
+func BulkEntries(database *sql.DB, req BulkRequest) (int, error) {
+	if len(req.IDs) == 0 {
+		return 0, fmt.Errorf("keine Einträge ausgewählt")
+	}
+	ids := make([]int, 0, len(req.IDs))
+	for _, s := range req.IDs {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("ungültige ID %q", s)
+		}
+		ids = append(ids, id)
+	}
+	switch req.Action {
+	case BulkBlock:
+		return db.BulkStatus(database, req.Pool, ids, "b")
+	case BulkWhitelist:
+		return db.BulkStatus(database, req.Pool, ids, "w")
+	case BulkDelete:
+		return db.BulkDelete(database, req.Pool, ids)
+	case BulkMove:
+		target := strings.TrimSpace(req.Target)
+		if target == req.Pool {
+			return 0, fmt.Errorf("die Einträge sind bereits im Pool %s", target)
+		}
+		return db.BulkMove(database, req.Pool, ids, target)
+	case BulkExpire:
+		expiresAt := strings.TrimSpace(req.ExpiresAt)
+		if expiresAt != "" {
+			if _, err := time.Parse(tokenExpiryLayout, expiresAt); err != nil {
+				return 0, fmt.Errorf("ungültiges Ablaufdatum %q (JJJJ-MM-TT)", expiresAt)
+			}
+		}
+		return db.BulkExpire(database, req.Pool, ids, expiresAt)
+	}
+	return 0, fmt.Errorf("unbekannte Aktion %q (%s)", req.Action, strings.Join(BulkActions, ", "))
+}
+
+// DeleteExpired löscht die Einträge, deren Ablaufdatum vorbei ist. Wie bei
+// den Tokens gilt ein Eintrag bis und mit dem Ablaufdatum.
+func DeleteExpired(database *sql.DB) (int, error) {
+	n, err := db.DeleteExpired(database, time.Now().Format(tokenExpiryLayout))
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		app.LogIt.Info(fmt.Sprintf("%d abgelaufene Einträge gelöscht, die Listen ändern sich mit der nächsten Aktivierung", n))
+	}
+	return n, nil
+}
+
+// StartExpiry löscht abgelaufene Einträge beim Start und danach stündlich im
+// Hintergrund
+func StartExpiry(database *sql.DB) {
+	check := func() {
+		if _, err := DeleteExpired(database); err != nil {
+			app.LogIt.Error(fmt.Sprintf("Löschen abgelaufener Einträge fehlgeschlagen: %v", err))
+		}
+	}
+	go func() {
+		check()
+		ticker := time.NewTicker(expiryCheckPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+}
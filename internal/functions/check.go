@@ -0,0 +1,151 @@
+package functions
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// MaxCheckInputs begrenzt die Anzahl Adressen einer Prüfung
+const MaxCheckInputs = 5000
+
+var (
+	checkRange = regexp.MustCompile(`((?:[0-9]{1,3}\.){3}[0-9]{1,3})\s*-\s*((?:[0-9]{1,3}\.){3}[0-9]{1,3})`)
+	checkCIDR  = regexp.MustCompile(`(?:[0-9]{1,3}\.){3}[0-9]{1,3}(?:/[0-9]{1,2})?`)
+)
+
+// CheckResult ist eine geprüfte Angabe mit allen Einträgen, die sie berühren,
+// der spezifischste zuerst. Error ist gesetzt, wenn die Angabe ungültig ist.
+type CheckResult struct {
+	Input   string
+	Start   uint32
+	End     uint32
+	Error   string
+	Entries []db.PoolEntry
+}
+
+// CheckReport fasst eine Prüfung zusammen. Unparsed enthält die Zeilen ohne
+// erkennbare Adresse mit Zeilennummer.
+type CheckReport struct {
+	Results  []CheckResult
+	Unparsed []string
+	Found    int
+}
+
+// ParseCheckInput liest die Adressen aus einer Eingabe oder Datei: IPs, CIDRs
+// und Bereiche, auch mehrere je Zeile oder mitten in Logzeilen. Eine Zeile,
+// die nur aus einer partiellen Adresse wie "10.1." besteht, wird wie bei
+// Apache verstanden. Doppelte Angaben werden nur einmal geprüft.
+func ParseCheckInput(r io.Reader) (inputs []string, unparsed []string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			inputs = append(inputs, s)
+		}
+	}
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		if line == "" {
+			continue
+		}
+		found := false
+		for _, m := range checkRange.FindAllStringSubmatch(line, -1) {
+			add(m[1] + " - " + m[2])
+			found = true
+		}
+		rest := checkRange.ReplaceAllString(line, " ")
+		for _, m := range checkCIDR.FindAllString(rest, -1) {
+			add(m)
+			found = true
+		}
+		if !found {
+			if strings.ContainsAny(line, " \t,;") {
+				unparsed = append(unparsed, fmt.Sprintf("Zeile %d: %s", lineNo, line))
+				continue
+			}
+			add(line)
+		}
+		if len(inputs) > MaxCheckInputs {
+			return nil, nil, fmt.Errorf("höchstens %d Adressen je Prüfung", MaxCheckInputs)
+		}
+	}
+	return inputs, unparsed, scanner.Err()
+}
+
+// CheckAddresses sucht zu jeder Angabe aus r alle Einträge, die sie berühren.
+// Ist pools nicht leer, zählen nur Einträge dieser Pools, z.B. für beschränkte
+// Tokens.
+func CheckAddresses(database *sql.DB, r io.Reader, pools []string) (*CheckReport, error) {
+	inputs, unparsed, err := ParseCheckInput(r)
+	if err != nil {
+		return nil, err
+	}
+	report := &CheckReport{Unparsed: unparsed}
+	for _, input := range inputs {
+		res := CheckResult{Input: input}
+		start, end, err := helpers.ParseIPSpec(input)
+		if err != nil {
+			res.Error = err.Error()
+			report.Results = append(report.Results, res)
+			continue
+		}
+		res.Start, res.End = start, end
+		if res.Entries, err = db.FindOverlapping(database, start, end); err != nil {
+			return nil, err
+		}
+		if len(pools) > 0 {
+			res.Entries = slices.DeleteFunc(res.Entries, func(e db.PoolEntry) bool {
+				return !slices.Contains(pools, e.Name)
+			})
+		}
+		if len(res.Entries) > 0 {
+			report.Found++
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+// WriteCheckCSV schreibt je Treffer eine Zeile, Angaben ohne Treffer oder
+// ungültige Angaben mit einem Hinweis
+func WriteCheckCSV(w io.Writer, results []CheckResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"eingabe", "pool", "cidr", "status", "kommentar", "hinweis"}); err != nil {
+		return err
+	}
+	for _, res := range results {
+		var err error
+		switch {
+		case res.Error != "":
+			err = cw.Write([]string{res.Input, "", "", "", "", res.Error})
+		case len(res.Entries) == 0:
+			err = cw.Write([]string{res.Input, "", "", "", "", "nicht registriert"})
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range res.Entries {
+			if err := cw.Write([]string{res.Input, e.Name, e.CIDR, e.Status, e.Comment, ""}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,44 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+func TestCheckAddressesPools(t *testing.T) {
+	database := testDB(t)
+
+	for _, e := range []struct{ cidr, pool string }{
+		{"192.0.2.0/24", "netz"}, {"192.0.2.7", "web"}, {"198.51.100.1", "netz"},
+	} {
+		if err := db.InsertPoollistEntry(database, e.cidr, e.pool, "", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	input := "192.0.2.7\n198.51.100.1\n"
+
+	report, err := CheckAddresses(database, strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Found != 2 || len(report.Results[0].Entries) != 2 {
+		t.Fatalf("ohne Pools: %d gefunden, %v", report.Found, report.Results[0].Entries)
+	}
+
+	// nur Treffer in den angegebenen Pools zählen
+	report, err = CheckAddresses(database, strings.NewReader(input), []string{"web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Found != 1 {
+		t.Errorf("mit Pool web: %d gefunden, erwartet 1", report.Found)
+	}
+	if entries := report.Results[0].Entries; len(entries) != 1 || entries[0].Name != "web" {
+		t.Errorf("Treffer für 192.0.2.7 = %v, erwartet nur web", entries)
+	}
+	if entries := report.Results[1].Entries; len(entries) != 0 {
+		t.Errorf("Treffer für 198.51.100.1 = %v, erwartet keine", entries)
+	}
+}
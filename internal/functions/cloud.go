@@ -0,0 +1,168 @@
+package functions
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// Cloudflare akzeptiert in IP-Listen nur IPv4-Präfixe von /8 bis /32
+const cloudflareMinPrefix = 8
+
+// AWS erlaubt in IPSet-Namen nur Buchstaben, Ziffern, _ und -, in der
+// Beschreibung keine Klammern
+var awsNameInvalid = regexp.MustCompile(`[^\w-]`)
+
+// awsIPSet entspricht der Eingabe von "aws wafv2 create-ip-set --cli-input-json"
+type awsIPSet struct {
+	Name             string   `json:"Name"`
+	Scope            string   `json:"Scope"`
+	Description      string   `json:"Description"`
+	IPAddressVersion string   `json:"IPAddressVersion"`
+	Addresses        []string `json:"Addresses"`
+}
+
+// cloudflareItem ist ein Eintrag im Bulk-Format der Cloudflare IP-Listen
+type cloudflareItem struct {
+	IP      string `json:"ip"`
+	Comment string `json:"comment,omitempty"`
+}
+
+func statusWord(status string) string {
+	if status == "w" {
+		return "allow"
+	}
+	return "block"
+}
+
+// chunkName hängt bei mehr als einem Teil die Nummer des Teils an
+func chunkName(base string, part, parts int) string {
+	if parts == 1 {
+		return base
+	}
+	return fmt.Sprintf("%s-%d", base, part)
+}
+
+// renderAWSWAF schreibt je Pool und Status ein IPSet, aufgeteilt in Teile zu
+// höchstens maxAddresses Adressen
+func renderAWSWAF(exports []ExportData) ([]exportFile, error) {
+	cfg := app.Config.AWSWAF
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.MaxAddresses <= 0 {
+		return nil, fmt.Errorf("awsWaf: maxAddresses muss grösser als 0 sein")
+	}
+	var files []exportFile
+	for _, data := range exports {
+		var addresses []string
+		seen := map[netip.Prefix]bool{}
+		for _, e := range data.Entries {
+			prefix, err := canonicalPrefix(e.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("awsWaf: Pool %s: %w", data.Pool, err)
+			}
+			if !seen[prefix] {
+				seen[prefix] = true
+				addresses = append(addresses, prefix.String())
+			}
+		}
+		base := awsNameInvalid.ReplaceAllString(cfg.NamePrefix+"-"+data.Pool+"-"+statusWord(data.Status), "-")
+		parts := (len(addresses) + cfg.MaxAddresses - 1) / cfg.MaxAddresses
+		for part := 1; part <= parts; part++ {
+			chunk := addresses[(part-1)*cfg.MaxAddresses : min(part*cfg.MaxAddresses, len(addresses))]
+			name := chunkName(base, part, parts)
+			if len(name) > 128 {
+				return nil, fmt.Errorf("awsWaf: IPSet-Name %s ist länger als 128 Zeichen", name)
+			}
+			content, err := json.MarshalIndent(awsIPSet{
+				Name:             name,
+				Scope:            cfg.Scope,
+				Description:      fmt.Sprintf("blv %s %s %d/%d", statusWord(data.Status), awsNameInvalid.ReplaceAllString(data.Pool, "-"), part, parts),
+				IPAddressVersion: "IPV4",
+				Addresses:        chunk,
+			}, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, exportFile{Path: cfg.OutputDir + name + ".json", Content: append(content, '\n')})
+		}
+	}
+	return files, nil
+}
+
+// renderCloudflare schreibt je Pool und Status eine Liste im Bulk-Format,
+// aufgeteilt in Teile zu höchstens maxItems Einträgen. Netze grösser als /8
+// werden in /8 aufgeteilt.
+func renderCloudflare(exports []ExportData) ([]exportFile, error) {
+	cfg := app.Config.Cloudflare
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.MaxItems <= 0 {
+		return nil, fmt.Errorf("cloudflare: maxItems muss grösser als 0 sein")
+	}
+	var files []exportFile
+	for _, data := range exports {
+		var items []cloudflareItem
+		seen := map[string]bool{}
+		for _, e := range data.Entries {
+			cidrs, err := cloudflarePrefixes(e)
+			if err != nil {
+				return nil, fmt.Errorf("cloudflare: Pool %s: %w", data.Pool, err)
+			}
+			for _, cidr := range cidrs {
+				if !seen[cidr] {
+					seen[cidr] = true
+					items = append(items, cloudflareItem{IP: cidr, Comment: e.Comment})
+				}
+			}
+		}
+		base := data.Pool + "-" + statusWord(data.Status)
+		parts := (len(items) + cfg.MaxItems - 1) / cfg.MaxItems
+		for part := 1; part <= parts; part++ {
+			chunk := items[(part-1)*cfg.MaxItems : min(part*cfg.MaxItems, len(items))]
+			content, err := json.MarshalIndent(chunk, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, exportFile{Path: cfg.OutputDir + chunkName(base, part, parts) + ".json", Content: append(content, '\n')})
+		}
+	}
+	return files, nil
+}
+
+// canonicalPrefix liefert ein CIDR mit auf die Netzadresse maskierter IPv4-
+// Adresse, z.B. 10.1.2.3/8 als 10.0.0.0/8, damit AWS WAF und Cloudflare
+// dieselben Netze in derselben Schreibweise erhalten
+func canonicalPrefix(cidr string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !prefix.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("%s ist keine IPv4-Adresse", cidr)
+	}
+	return prefix.Masked(), nil
+}
+
+// cloudflarePrefixes liefert den Eintrag als CIDR mit mindestens /8
+func cloudflarePrefixes(e db.PoolEntry) ([]string, error) {
+	prefix, err := canonicalPrefix(e.CIDR)
+	if err != nil {
+		return nil, err
+	}
+	if prefix.Bits() >= cloudflareMinPrefix {
+		return []string{prefix.String()}, nil
+	}
+	var cidrs []string
+	first := int(prefix.Addr().As4()[0])
+	for i := 0; i < 1<<(cloudflareMinPrefix-prefix.Bits()); i++ {
+		cidrs = append(cidrs, fmt.Sprintf("%d.0.0.0/8", first+i))
+	}
+	return cidrs, nil
+}
@@ -0,0 +1,64 @@
+package functions
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+func TestCloudExportsCanonical(t *testing.T) {
+	saved := app.Config
+	t.Cleanup(func() { app.Config = saved })
+	app.Config.AWSWAF = app.AWSWAFConfig{Enabled: true, OutputDir: "aws/", Scope: "REGIONAL", NamePrefix: "blv", MaxAddresses: 100}
+	app.Config.Cloudflare = app.CloudflareConfig{Enabled: true, OutputDir: "cf/", MaxItems: 100}
+
+	exports := []ExportData{{Pool: "test", Status: "b", Entries: []db.PoolEntry{
+		{CIDR: "192.0.2.7/32"},
+		{CIDR: "10.1.2.3/8"},
+		{CIDR: "10.0.0.0/8"},
+		{CIDR: "198.51.100.77/24"},
+		{CIDR: "4.5.6.7/6"},
+	}}}
+
+	aws, err := renderAWSWAF(exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set awsIPSet
+	if err := json.Unmarshal(aws[0].Content, &set); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.7/32", "10.0.0.0/8", "198.51.100.0/24", "4.0.0.0/6"}
+	if !slices.Equal(set.Addresses, want) {
+		t.Errorf("AWS WAF Addresses = %q, erwartet %q", set.Addresses, want)
+	}
+
+	cf, err := renderCloudflare(exports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []cloudflareItem
+	if err := json.Unmarshal(cf[0].Content, &items); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.IP)
+	}
+	want = []string{"192.0.2.7/32", "10.0.0.0/8", "198.51.100.0/24", "4.0.0.0/8", "5.0.0.0/8", "6.0.0.0/8", "7.0.0.0/8"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Cloudflare = %q, erwartet %q", got, want)
+	}
+
+	// ungültige Einträge brechen beide Exporte ab
+	bad := []ExportData{{Pool: "test", Status: "b", Entries: []db.PoolEntry{{CIDR: "2001:db8::/32"}}}}
+	if _, err := renderAWSWAF(bad); err == nil {
+		t.Error("AWS WAF mit IPv6-Eintrag ohne Fehler")
+	}
+	if _, err := renderCloudflare(bad); err == nil {
+		t.Error("Cloudflare mit IPv6-Eintrag ohne Fehler")
+	}
+}
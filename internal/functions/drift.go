@@ -0,0 +1,165 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// DriftedFile ist eine Datei im ListPath, die seit dem letzten Schreiben
+// durch blv von Hand verändert oder gelöscht wurde
+type DriftedFile struct {
+	File      string
+	Status    string // geändert, gelöscht
+	WrittenAt string
+	Diff      []string
+	// Importable ist false für die generierte Include-Datei
+	Importable bool
+}
+
+// RecordListFiles hält Prüfsumme und Inhalt der angegebenen Dateien (relativ
+// zum ListPath) fest, damit spätere Änderungen von Hand erkannt werden.
+func RecordListFiles(database *sql.DB, files []string) error {
+	for _, rel := range files {
+		path := filepath.Join(app.Config.ListPath, rel)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		checksum, err := helpers.CheckSum(sha256.New(), path)
+		if err != nil {
+			return err
+		}
+		if err := db.SaveListFile(database, rel, checksum, string(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceListFileRecords ersetzt alle festgehaltenen Dateien durch files
+func ReplaceListFileRecords(database *sql.DB, files []string) error {
+	recorded, err := db.ListListFiles(database)
+	if err != nil {
+		return err
+	}
+	for _, f := range recorded {
+		if !helpers.StringInSlice(f.Path, files) {
+			if err := db.DeleteListFile(database, f.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return RecordListFiles(database, files)
+}
+
+// DetectDrift vergleicht die Dateien im ListPath mit den festgehaltenen
+// Prüfsummen
+func DetectDrift(database *sql.DB) ([]DriftedFile, error) {
+	recorded, err := db.ListListFiles(database)
+	if err != nil {
+		return nil, err
+	}
+	var drifted []DriftedFile
+	for _, f := range recorded {
+		path := filepath.Join(app.Config.ListPath, f.Path)
+		d := DriftedFile{
+			File:       f.Path,
+			WrittenAt:  f.WrittenAt,
+			Importable: f.Path != app.Config.MasterInclude,
+		}
+		if !helpers.FileExists(path) {
+			d.Status = "gelöscht"
+			d.Diff = helpers.UnifiedDiff(f.Path, "/dev/null", f.Content, "")
+			drifted = append(drifted, d)
+			continue
+		}
+		checksum, err := helpers.CheckSum(sha256.New(), path)
+		if err != nil {
+			return nil, err
+		}
+		if checksum == f.Checksum {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		d.Status = "geändert"
+		d.Diff = helpers.UnifiedDiff(f.Path+" (von blv geschrieben)", f.Path+" (aktuell)", f.Content, string(content))
+		drifted = append(drifted, d)
+	}
+	return drifted, nil
+}
+
+// LogDrift schreibt von Hand veränderte Dateien ins Log und auf die Konsole
+func LogDrift(database *sql.DB) {
+	drifted, err := DetectDrift(database)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Fehler beim Prüfen der Listen auf Änderungen von Hand: %v", err))
+		return
+	}
+	for _, d := range drifted {
+		msg := fmt.Sprintf("%s wurde seit dem Schreiben am %s von Hand %s", d.File, d.WrittenAt, d.Status)
+		app.LogIt.Warn(msg)
+		fmt.Println("WARNUNG:", msg)
+	}
+}
+
+// ImportDrift übernimmt eine von Hand veränderte Liste in die Datenbank: die
+// Einträge des Pools mit dem Status der Liste werden durch den Inhalt der
+// Datei ersetzt. Wurde die Datei gelöscht, werden die Einträge gelöscht.
+func ImportDrift(database *sql.DB, rel string) error {
+	dir, file := filepath.Split(rel)
+	var status string
+	switch dir {
+	case "whitelists/":
+		status = "w"
+	case "blocklists/":
+		status = "b"
+	default:
+		return fmt.Errorf("%s ist keine Liste und kann nicht importiert werden", rel)
+	}
+	poolName := strings.TrimSuffix(file, filepath.Ext(file))
+	path := filepath.Join(app.Config.ListPath, rel)
+
+	var desired []db.PoolEntry
+	var unparsed []string
+	if helpers.FileExists(path) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if desired, err = confToEntries(data, poolName, status, &unparsed); err != nil {
+			return err
+		}
+	}
+	entries, err := db.ListByPool(database, poolName)
+	if err != nil {
+		return err
+	}
+	current := slices.DeleteFunc(entries, func(e db.PoolEntry) bool { return e.Status != status })
+	if len(unparsed) > 0 {
+		// sonst würden die nicht verstandenen Einträge aus der Datenbank gelöscht
+		return fmt.Errorf("%s enthält nicht verstandene Zeilen und wird nicht übernommen: %s", rel, strings.Join(unparsed, "; "))
+	}
+	report := &ReconcileReport{}
+	inserts, deletes, updates := reconcileEntries(current, desired, report)
+	if err := db.ApplyChanges(database, inserts, deletes, updates); err != nil {
+		return err
+	}
+	if !helpers.FileExists(path) {
+		app.LogIt.Info(fmt.Sprintf("%s wurde von Hand gelöscht, Einträge von %s entfernt", rel, poolName))
+		return db.DeleteListFile(database, rel)
+	}
+	app.LogIt.Info(fmt.Sprintf("Änderungen von Hand an %s übernommen: %d hinzugefügt, %d entfernt, %d geändert", rel, report.Added, report.Removed, report.Changed))
+	return RecordListFiles(database, []string{rel})
+}
@@ -0,0 +1,176 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// ExportData wird an die exportTemplates übergeben
+type ExportData struct {
+	Pool    string
+	Status  string // w oder b
+	Entries []db.PoolEntry
+}
+
+// exportFile ist eine gerenderte Datei, die bei der Aktivierung geschrieben wird
+type exportFile struct {
+	Path    string
+	Content []byte
+}
+
+// poolExports liefert die Einträge aller Pools getrennt nach Status, Einträge
+// ohne Status werden wie beim Apache-Export übergangen
+func poolExports(database *sql.DB) ([]ExportData, error) {
+	entries, err := db.ListEntries(database)
+	if err != nil {
+		return nil, err
+	}
+	var exports []ExportData
+	for _, e := range entries {
+		if e.Status != "w" && e.Status != "b" {
+			continue
+		}
+		if n := len(exports); n == 0 || exports[n-1].Pool != e.Name || exports[n-1].Status != e.Status {
+			exports = append(exports, ExportData{Pool: e.Name, Status: e.Status})
+		}
+		exports[len(exports)-1].Entries = append(exports[len(exports)-1].Entries, e)
+	}
+	return exports, nil
+}
+
+// renderExports rendert alle zusätzlichen Exporte. Es wird noch nichts
+// geschrieben, damit ein fehlerhafter Export die Aktivierung abbricht, bevor
+// Listen ausgetauscht werden.
+func renderExports(database *sql.DB) ([]exportFile, error) {
+	exports, err := poolExports(database)
+	if err != nil {
+		return nil, err
+	}
+	files, err := renderExportTemplates(exports)
+	if err != nil {
+		return nil, err
+	}
+	modSecurity, err := renderModSecurity(database, exports)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, modSecurity...)
+	for _, render := range []func([]ExportData) ([]exportFile, error){renderAWSWAF, renderCloudflare} {
+		rendered, err := render(exports)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rendered...)
+	}
+	return files, nil
+}
+
+// WriteExports schreibt die zusätzlichen Exporte ohne Aktivierung, z.B. für
+// eine eigene Pipeline
+func WriteExports(database *sql.DB) ([]string, error) {
+	files, err := renderExports(database)
+	if err != nil {
+		return nil, err
+	}
+	written, _, err := writeExportFiles(database, files)
+	return written, err
+}
+
+// renderExportTemplates rendert alle exportTemplates je Pool und Status
+func renderExportTemplates(exports []ExportData) ([]exportFile, error) {
+	var files []exportFile
+	for _, et := range app.Config.ExportTemplates {
+		text := et.Template
+		if et.TemplateFile != "" {
+			data, err := os.ReadFile(et.TemplateFile)
+			if err != nil {
+				return nil, fmt.Errorf("exportTemplate %s: %w", et.Name, err)
+			}
+			text = string(data)
+		}
+		content, err := template.New(et.Name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("exportTemplate %s: %w", et.Name, err)
+		}
+		fileName, err := template.New(et.Name + " fileName").Parse(et.FileName)
+		if err != nil {
+			return nil, fmt.Errorf("exportTemplate %s: fileName: %w", et.Name, err)
+		}
+		for _, data := range exports {
+			var name, buf bytes.Buffer
+			if err := fileName.Execute(&name, data); err != nil {
+				return nil, fmt.Errorf("exportTemplate %s: fileName: %w", et.Name, err)
+			}
+			// der Dateiname darf nicht aus dem outputDir hinausführen
+			base := name.String()
+			if base == "" || base != filepath.Base(base) || strings.HasPrefix(base, ".") {
+				return nil, fmt.Errorf("exportTemplate %s: ungültiger Dateiname %q für Pool %s", et.Name, base, data.Pool)
+			}
+			if err := content.Execute(&buf, data); err != nil {
+				return nil, fmt.Errorf("exportTemplate %s: Pool %s: %w", et.Name, data.Pool, err)
+			}
+			files = append(files, exportFile{Path: et.OutputDir + base, Content: buf.Bytes()})
+		}
+	}
+	return files, nil
+}
+
+// writeExportFiles schreibt die gerenderten Dateien, jede über eine temporäre
+// Datei und Rename, damit Leser nie eine halbe Datei sehen. Danach werden die
+// Exporte entfernt, die blv früher geschrieben hat und die nicht mehr
+// gerendert werden, z.B. von gelöschten Pools oder weggefallenen Teilen.
+// Entfernt wird nur, was in der Datenbank als Export festgehalten ist.
+func writeExportFiles(database *sql.DB, files []exportFile) (written, removed []string, err error) {
+	previous, err := db.ListExportFiles(database)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := make([]string, 0, len(files))
+	for _, f := range files {
+		abs, err := filepath.Abs(f.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		current = append(current, abs)
+	}
+	// vor dem Schreiben festhalten, damit auch nach einem Abbruch keine
+	// geschriebene Datei unbekannt bleibt
+	if err := db.ReplaceExportFiles(database, slices.Concat(previous, current)); err != nil {
+		return nil, nil, err
+	}
+
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Dir(f.Path), 0o750); err != nil {
+			return written, nil, err
+		}
+		tmp := f.Path + ".tmp"
+		if err := os.WriteFile(tmp, f.Content, 0o644); err != nil {
+			return written, nil, err
+		}
+		if err := os.Rename(tmp, f.Path); err != nil {
+			return written, nil, err
+		}
+		written = append(written, f.Path)
+	}
+
+	for _, path := range previous {
+		if slices.Contains(current, path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return written, removed, err
+		}
+		app.LogIt.Info(fmt.Sprintf("verwaister Export %s entfernt", path))
+		removed = append(removed, path)
+	}
+	return written, removed, db.ReplaceExportFiles(database, current)
+}
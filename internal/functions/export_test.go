@@ -0,0 +1,90 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// exportDir liefert die Dateien in dir
+func exportDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestWriteExportsRemovesOrphans(t *testing.T) {
+	database := testDB(t)
+	dir := t.TempDir() + "/"
+	app.Config.Cloudflare = app.CloudflareConfig{Enabled: true, OutputDir: dir, MaxItems: 2}
+
+	for _, e := range []struct{ cidr, pool string }{
+		{"192.0.2.1", "gross"}, {"192.0.2.2", "gross"}, {"192.0.2.3", "gross"},
+		{"198.51.100.0/24", "weg"},
+	} {
+		if _, err := db.InsertEntry(database, e.cidr, e.pool, "", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// fremde Dateien im outputDir bleiben unberührt
+	if err := os.WriteFile(filepath.Join(dir, "fremd.json"), []byte("[]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := WriteExports(database); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fremd.json", "gross-block-1.json", "gross-block-2.json", "weg-block.json"}
+	if got := exportDir(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("Exporte = %q, erwartet %q", got, want)
+	}
+
+	// Pool gelöscht, der andere passt wieder in einen Teil
+	for _, pool := range []string{"gross", "weg"} {
+		if err := db.DeletePoolStatus(database, pool, "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.InsertEntry(database, "192.0.2.1", "gross", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := renderExports(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, removed, err := writeExportFiles(database, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{dir + "gross-block.json"}; !slices.Equal(written, want) {
+		t.Errorf("geschrieben = %q, erwartet %q", written, want)
+	}
+	wantRemoved := []string{dir + "gross-block-1.json", dir + "gross-block-2.json", dir + "weg-block.json"}
+	if !slices.Equal(removed, wantRemoved) {
+		t.Errorf("entfernt = %q, erwartet %q", removed, wantRemoved)
+	}
+	want = []string{"fremd.json", "gross-block.json"}
+	if got := exportDir(t, dir); !slices.Equal(got, want) {
+		t.Errorf("Exporte = %q, erwartet %q", got, want)
+	}
+
+	// ein abgeschalteter Export wird ganz entfernt
+	app.Config.Cloudflare.Enabled = false
+	if _, err := WriteExports(database); err != nil {
+		t.Fatal(err)
+	}
+	if got := exportDir(t, dir); !slices.Equal(got, []string{"fremd.json"}) {
+		t.Errorf("Exporte nach dem Abschalten = %q", got)
+	}
+}
@@ -0,0 +1,237 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+const (
+	timestampLayout  = "2006-01-02 15:04:05"
+	feedHistoryKeep  = 50
+	maxFeedSize      = 32 << 20
+	feedCheckPeriod  = time.Minute
+	feedResultUpdate = "aktualisiert"
+	feedResultSame   = "unverändert"
+	feedResultError  = "fehler"
+)
+
+// FeedClient wird für alle Abrufe verwendet und lässt sich z.B. für Tests
+// gegen einen lokalen Server ersetzen
+var FeedClient = &http.Client{Timeout: time.Minute}
+
+// verhindert, dass Zeitplan und Admin-Seite denselben Feed gleichzeitig abrufen
+var feedMu sync.Mutex
+
+// Feed ist ein konfigurierter Feed mit dem Stand des letzten Abrufs
+type Feed struct {
+	app.FeedConfig
+	State   *db.FeedState
+	History []db.FeedHistory
+}
+
+// FeedByName liefert den konfigurierten Feed für einen Pool
+func FeedByName(name string) (app.FeedConfig, bool) {
+	for _, f := range app.Config.Feeds {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return app.FeedConfig{}, false
+}
+
+// ListFeeds liefert alle konfigurierten Feeds mit Stand und den letzten
+// historyLimit Abrufen
+func ListFeeds(database *sql.DB, historyLimit int) ([]Feed, error) {
+	var feeds []Feed
+	for _, f := range app.Config.Feeds {
+		st, err := db.GetFeedState(database, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		history, err := db.ListFeedHistory(database, f.Name, historyLimit)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, Feed{FeedConfig: f, State: st, History: history})
+	}
+	return feeds, nil
+}
+
+// FetchFeed ruft einen Feed ab und gleicht den Pool mit dem Inhalt ab. Dank
+// ETag und Last-Modified wird eine unveränderte Liste nicht erneut geladen.
+// Liefert der Feed keine Einträge, bleibt der Pool unverändert.
+func FetchFeed(database *sql.DB, feed app.FeedConfig) (*db.FeedHistory, error) {
+	feedMu.Lock()
+	defer feedMu.Unlock()
+
+	st, err := db.GetFeedState(database, feed.Name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Format(timestampLayout)
+	h := &db.FeedHistory{Name: feed.Name, FetchedAt: now}
+	st.LastFetch = now
+
+	report, err := fetchAndReconcile(database, feed, st, h)
+	if err != nil {
+		h.Result = feedResultError
+		h.Message = err.Error()
+		st.LastError = err.Error()
+		app.LogIt.Error(fmt.Sprintf("Feed %s konnte nicht abgerufen werden: %v", feed.Name, err))
+	} else {
+		st.LastError = ""
+		st.LastSuccess = now
+		if report != nil {
+			h.Result = feedResultUpdate
+			h.Added, h.Removed, h.Changed = report.Added, report.Removed, report.Changed
+			if len(report.Unparsed) > 0 {
+				h.Message = fmt.Sprintf("%d Zeilen nicht verstanden", len(report.Unparsed))
+			}
+			app.LogIt.Info(fmt.Sprintf("Feed %s abgerufen: %d hinzugefügt, %d entfernt, %d geändert", feed.Name, h.Added, h.Removed, h.Changed))
+		} else {
+			h.Result = feedResultSame
+			app.LogIt.Debug(fmt.Sprintf("Feed %s unverändert", feed.Name))
+		}
+	}
+
+	if saveErr := db.SaveFeedState(database, st); saveErr != nil {
+		return h, saveErr
+	}
+	if saveErr := db.AddFeedHistory(database, h, feedHistoryKeep); saveErr != nil {
+		return h, saveErr
+	}
+	return h, err
+}
+
+// fetchAndReconcile liefert nil ohne Fehler, wenn sich der Feed nicht
+// geändert hat
+func fetchAndReconcile(database *sql.DB, feed app.FeedConfig, st *db.FeedState, h *db.FeedHistory) (*ReconcileReport, error) {
+	req, err := http.NewRequest(http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// bedingte Anfrage nur, wenn der letzte Stand auch übernommen wurde. Ein
+	// leerer Feed wird nie übernommen und bis zum ersten brauchbaren Stand bei
+	// jedem fälligen Abruf vollständig geladen, damit er sofort greift, sobald
+	// er wieder Einträge hat.
+	if st.Entries > 0 {
+		if st.ETag != "" {
+			req.Header.Set("If-None-Match", st.ETag)
+		}
+		if st.LastModified != "" {
+			req.Header.Set("If-Modified-Since", st.LastModified)
+		}
+	}
+	req.Header.Set("User-Agent", app.ApplicationName)
+
+	resp, err := FeedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	h.HTTPStatus = resp.StatusCode
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unerwarteter Status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFeedSize {
+		return nil, fmt.Errorf("Feed ist grösser als %d Bytes", maxFeedSize)
+	}
+
+	var status string
+	switch feed.Format {
+	case "plain":
+		data = plainToConf(data)
+		status = feed.Status
+	case "apache":
+		// Require [not] ip bestimmt den Status selbst
+	default:
+		return nil, fmt.Errorf("unbekanntes Format %q (plain oder apache)", feed.Format)
+	}
+
+	report := &ReconcileReport{}
+	desired, err := confToEntries(data, feed.Name, status, &report.Unparsed)
+	if err != nil {
+		return nil, err
+	}
+	if len(desired) == 0 {
+		return nil, fmt.Errorf("Feed enthält keine Einträge, Pool %s bleibt unverändert", feed.Name)
+	}
+	current, err := db.ListByPool(database, feed.Name)
+	if err != nil {
+		return nil, err
+	}
+	inserts, deletes, updates := reconcileEntries(current, desired, report)
+	if err := db.ApplyChanges(database, inserts, deletes, updates); err != nil {
+		return nil, err
+	}
+	report.Applied = true
+
+	st.ETag = resp.Header.Get("ETag")
+	st.LastModified = resp.Header.Get("Last-Modified")
+	st.Entries = len(desired)
+	return report, nil
+}
+
+// plainToConf wandelt ";"-Kommentare wie in Spamhaus DROP
+// ("1.10.16.0/20 ; SBL256894") in "#"-Kommentare um
+func plainToConf(data []byte) []byte {
+	var buf bytes.Buffer
+	for line := range bytes.Lines(data) {
+		buf.Write(bytes.Replace(line, []byte(";"), []byte("#"), 1))
+	}
+	return buf.Bytes()
+}
+
+// feedDue meldet, ob der Feed gemäss seinem Intervall abgerufen werden muss
+func feedDue(database *sql.DB, feed app.FeedConfig, now time.Time) bool {
+	st, err := db.GetFeedState(database, feed.Name)
+	if err != nil {
+		app.LogIt.Error(fmt.Sprintf("Stand von Feed %s konnte nicht gelesen werden: %v", feed.Name, err))
+		return false
+	}
+	last, err := time.ParseInLocation(timestampLayout, st.LastFetch, time.Local)
+	if err != nil {
+		return true
+	}
+	return !now.Before(last.Add(feed.Interval))
+}
+
+// StartFeeds prüft im Hintergrund jede Minute, welche Feeds fällig sind,
+// und ruft sie ab
+func StartFeeds(database *sql.DB) {
+	if len(app.Config.Feeds) == 0 {
+		return
+	}
+	check := func() {
+		for _, feed := range app.Config.Feeds {
+			if feedDue(database, feed, time.Now()) {
+				FetchFeed(database, feed)
+			}
+		}
+	}
+	go func() {
+		check()
+		ticker := time.NewTicker(feedCheckPeriod)
+		defer ticker.Stop()
+		for range ticker.C {
+			check()
+		}
+	}()
+	app.LogIt.Info(fmt.Sprintf("%d Feeds werden regelmässig abgerufen", len(app.Config.Feeds)))
+}
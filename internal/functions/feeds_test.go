@@ -0,0 +1,224 @@
+package functions
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// feedServer ist ein lokaler Ersatz für einen Feed. Er liefert body mit etag
+// bzw. lastModified und beantwortet passende bedingte Anfragen mit 304.
+type feedServer struct {
+	*httptest.Server
+	mu           sync.Mutex
+	status       int
+	body         string
+	etag         string
+	lastModified string
+	requests     []http.Header
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	t.Helper()
+	fs := &feedServer{status: http.StatusOK}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.requests = append(fs.requests, r.Header.Clone())
+		if fs.status != http.StatusOK {
+			http.Error(w, "kaputt", fs.status)
+			return
+		}
+		if fs.etag != "" {
+			w.Header().Set("ETag", fs.etag)
+			if r.Header.Get("If-None-Match") == fs.etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		if fs.lastModified != "" {
+			w.Header().Set("Last-Modified", fs.lastModified)
+			if r.Header.Get("If-Modified-Since") == fs.lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte(fs.body))
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func (fs *feedServer) set(status int, body, etag, lastModified string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.status, fs.body, fs.etag, fs.lastModified = status, body, etag, lastModified
+}
+
+// lastRequest liefert die Header der letzten Anfrage
+func (fs *feedServer) lastRequest() http.Header {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.requests[len(fs.requests)-1]
+}
+
+// poolContent liefert die Einträge eines Pools als "Status CIDR Kommentar"
+func poolContent(t *testing.T, database *sql.DB, pool string) []string {
+	t.Helper()
+	entries, err := db.ListByPool(database, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Status+" "+e.CIDR+" "+e.Comment)
+	}
+	slices.Sort(res)
+	return res
+}
+
+func fetchFeed(t *testing.T, database *sql.DB, feed app.FeedConfig) *db.FeedHistory {
+	t.Helper()
+	h, err := FetchFeed(database, feed)
+	if err != nil && h.Result != feedResultError {
+		t.Fatalf("FetchFeed: %v", err)
+	}
+	return h
+}
+
+func TestFetchFeedPlain(t *testing.T) {
+	database := testDB(t)
+	fs := newFeedServer(t)
+	feed := app.FeedConfig{Name: "drop", URL: fs.URL, Format: "plain", Status: "b"}
+
+	fs.set(http.StatusOK, "; Spamhaus DROP\n1.10.16.0/20 ; SBL256894\n192.0.2.0/24 ; SBL1\n198.51.100.7\n", `"v1"`, "")
+	h := fetchFeed(t, database, feed)
+	if h.Result != feedResultUpdate || h.HTTPStatus != http.StatusOK || h.Added != 2+1 {
+		t.Fatalf("erster Abruf: %+v", h)
+	}
+	want := []string{"b 1.10.16.0/20 SBL256894", "b 192.0.2.0/24 SBL1", "b 198.51.100.7/32 "}
+	if got := poolContent(t, database, "drop"); !slices.Equal(got, want) {
+		t.Fatalf("Pool nach dem ersten Abruf = %q, erwartet %q", got, want)
+	}
+
+	// unverändert: bedingte Anfrage mit dem ETag, 304
+	h = fetchFeed(t, database, feed)
+	if got := fs.lastRequest().Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, erwartet \"v1\"", got)
+	}
+	if h.Result != feedResultSame || h.HTTPStatus != http.StatusNotModified {
+		t.Errorf("zweiter Abruf: %+v", h)
+	}
+
+	// Einträge, die im Feed fehlen, werden entfernt
+	fs.set(http.StatusOK, "1.10.16.0/20 ; SBL256894\n203.0.113.0/24 ; SBL2\n", `"v2"`, "")
+	h = fetchFeed(t, database, feed)
+	if h.Result != feedResultUpdate || h.Added != 1 || h.Removed != 2 {
+		t.Errorf("dritter Abruf: %+v", h)
+	}
+	want = []string{"b 1.10.16.0/20 SBL256894", "b 203.0.113.0/24 SBL2"}
+	if got := poolContent(t, database, "drop"); !slices.Equal(got, want) {
+		t.Errorf("Pool nach dem dritten Abruf = %q, erwartet %q", got, want)
+	}
+}
+
+func TestFetchFeedApacheLastModified(t *testing.T) {
+	database := testDB(t)
+	fs := newFeedServer(t)
+	feed := app.FeedConfig{Name: "partner", URL: fs.URL, Format: "apache"}
+	lastModified := "Mon, 05 Oct 2026 10:00:00 GMT"
+
+	fs.set(http.StatusOK, "Require not ip 198.51.100.0/24 # böse\nRequire ip 203.0.113.1\n", "", lastModified)
+	h := fetchFeed(t, database, feed)
+	if h.Result != feedResultUpdate || h.Added != 2 {
+		t.Fatalf("erster Abruf: %+v", h)
+	}
+	want := []string{"b 198.51.100.0/24 böse", "w 203.0.113.1/32 "}
+	if got := poolContent(t, database, "partner"); !slices.Equal(got, want) {
+		t.Fatalf("Pool = %q, erwartet %q", got, want)
+	}
+
+	h = fetchFeed(t, database, feed)
+	if got := fs.lastRequest().Get("If-Modified-Since"); got != lastModified {
+		t.Errorf("If-Modified-Since = %q, erwartet %q", got, lastModified)
+	}
+	if h.Result != feedResultSame || h.HTTPStatus != http.StatusNotModified {
+		t.Errorf("zweiter Abruf: %+v", h)
+	}
+}
+
+func TestFetchFeedError(t *testing.T) {
+	database := testDB(t)
+	fs := newFeedServer(t)
+	feed := app.FeedConfig{Name: "drop", URL: fs.URL, Format: "plain", Status: "b"}
+
+	fs.set(http.StatusOK, "192.0.2.0/24\n", `"v1"`, "")
+	fetchFeed(t, database, feed)
+	before := poolContent(t, database, "drop")
+
+	fs.set(http.StatusInternalServerError, "", "", "")
+	h, err := FetchFeed(database, feed)
+	if err == nil || h.Result != feedResultError || h.HTTPStatus != http.StatusInternalServerError {
+		t.Fatalf("Abruf mit Fehler: %+v, %v", h, err)
+	}
+	if got := poolContent(t, database, "drop"); !slices.Equal(got, before) {
+		t.Errorf("Pool nach Fehler = %q, erwartet unverändert %q", got, before)
+	}
+	history, err := db.ListFeedHistory(database, "drop", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Result != feedResultError || history[0].HTTPStatus != http.StatusInternalServerError || history[0].Message == "" {
+		t.Errorf("Verlauf = %+v", history)
+	}
+	st, err := db.GetFeedState(database, "drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.LastError == "" || st.ETag != `"v1"` || st.Entries != 1 {
+		t.Errorf("Stand nach Fehler = %+v", st)
+	}
+}
+
+// Ein leerer Feed wird nie übernommen. Solange noch kein Stand übernommen
+// wurde, wird ohne If-None-Match angefragt und der Feed bei jedem fälligen
+// Abruf vollständig geladen, damit er greift, sobald er wieder Einträge hat.
+func TestFetchFeedEmpty(t *testing.T) {
+	database := testDB(t)
+	fs := newFeedServer(t)
+	feed := app.FeedConfig{Name: "drop", URL: fs.URL, Format: "plain", Status: "b"}
+
+	fs.set(http.StatusOK, "; leer\n", `"leer"`, "")
+	for range 2 {
+		h, err := FetchFeed(database, feed)
+		if err == nil || h.Result != feedResultError {
+			t.Fatalf("leerer Feed: %+v, %v", h, err)
+		}
+		if got := fs.lastRequest().Get("If-None-Match"); got != "" {
+			t.Errorf("If-None-Match = %q für einen nie übernommenen Feed", got)
+		}
+	}
+	if got := poolContent(t, database, "drop"); len(got) != 0 {
+		t.Errorf("Pool = %q, erwartet leer", got)
+	}
+
+	// nach einem übernommenen Stand lässt ein leerer Feed den Pool unverändert
+	fs.set(http.StatusOK, "192.0.2.0/24\n", `"v1"`, "")
+	fetchFeed(t, database, feed)
+	fs.set(http.StatusOK, "", `"v2"`, "")
+	if h, err := FetchFeed(database, feed); err == nil || h.Result != feedResultError {
+		t.Fatalf("leerer Feed nach Stand v1: %+v, %v", h, err)
+	}
+	if got := fs.lastRequest().Get("If-None-Match"); got != `"v1"` {
+		t.Errorf("If-None-Match = %q, erwartet den übernommenen Stand \"v1\"", got)
+	}
+	if got := poolContent(t, database, "drop"); !slices.Equal(got, []string{"b 192.0.2.0/24 "}) {
+		t.Errorf("Pool = %q, erwartet unverändert", got)
+	}
+}
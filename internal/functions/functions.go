@@ -8,24 +8,81 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	app "github.com/SvenKethz/fairdb/internal/configuration"
 	"github.com/SvenKethz/fairdb/internal/db"
 	"github.com/SvenKethz/fairdb/internal/helpers"
 )
 
-func ImportConf(database *sql.DB, r io.Reader, poolName string, status string) error {
+// ConfEntry ist ein aus einer Liste gelesener Eintrag
+type ConfEntry struct {
+	CIDR    string
+	Comment string
+	Status  string
+	// Group > 0 kennzeichnet CIDRs, die aus demselben IP-Bereich stammen
+	Group int
+}
+
+// ImportResult fasst einen Import zusammen. Unparsed enthält alle Adressen,
+// die nicht verstanden wurden, mit Zeilennummer.
+type ImportResult struct {
+	Imported int
+	Unparsed []string
+}
+
+func ImportConf(database *sql.DB, r io.Reader, poolName string, status string) (*ImportResult, error) {
+	if err := helpers.CheckPoolName(poolName); err != nil {
+		return nil, err
+	}
+	entries, unparsed, err := ParseConf(r, status)
+	if err != nil {
+		return nil, err
+	}
+	result := &ImportResult{Unparsed: unparsed}
+	for _, u := range unparsed {
+		app.LogIt.Warn(fmt.Sprintf("Import von %s: %s nicht verstanden", poolName, u))
+	}
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if e.Group > 0 {
+			// alle CIDRs des Bereichs gemeinsam anlegen
+			cidrs := []string{e.CIDR}
+			for i+1 < len(entries) && entries[i+1].Group == e.Group {
+				i++
+				cidrs = append(cidrs, entries[i].CIDR)
+			}
+			if err := db.InsertPoollistRange(database, cidrs, poolName, e.Comment, e.Status); err != nil {
+				return result, fmt.Errorf("Fehler beim Import des Bereichs ab %s: %w", e.CIDR, err)
+			}
+			result.Imported += len(cidrs)
+			continue
+		}
+		err := db.InsertPoollistEntry(database, e.CIDR, poolName, e.Comment, e.Status)
+		if err != nil {
+			return result, fmt.Errorf("Fehler beim Import von %s: %w", e.CIDR, err)
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+// ParseConf liest Apache-Listen (Require ip, Require not ip, Allow/Deny from)
+// sowie einfache IP-Listen. Eine Zeile darf mehrere Adressen enthalten,
+// partielle Adressen und Netzmasken werden in CIDRs umgewandelt, IP-Bereiche
+// in die minimale Menge von CIDRs.
+// Ist status leer, wird er aus der Direktive abgeleitet, Einträge einfacher
+// IP-Listen und Bereiche werden dann geblockt.
+func ParseConf(r io.Reader, status string) (entries []ConfEntry, unparsed []string, err error) {
 	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	group := 0
 
 	for scanner.Scan() {
+		lineNo++
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if !strings.HasPrefix(line, "Require") && !helpers.StartsWithIP(line) {
-			continue
-		}
 
 		// Kommentar abtrennen
 		var comment string
@@ -37,22 +94,87 @@ func ImportConf(database *sql.DB, r io.Reader, poolName string, status string) e
 			line = strings.TrimSpace(line[:idx])
 		}
 
-		var cidr string
-		for part := range strings.FieldsSeq(line) {
-			if helpers.StartsWithIP(part) {
-				cidr = part
+		fields := strings.Fields(line)
+		lineStatus := status
+		var hosts []string
+		switch strings.ToLower(fields[0]) {
+		case "require":
+			// Require [not] ip a b c - andere Require-Varianten enthalten keine Adressen
+			args := fields[1:]
+			negated := len(args) > 0 && strings.ToLower(args[0]) == "not"
+			if negated {
+				args = args[1:]
+			}
+			if len(args) == 0 || strings.ToLower(args[0]) != "ip" {
+				continue
+			}
+			hosts = args[1:]
+			if lineStatus == "" {
+				lineStatus = "w"
+				if negated {
+					lineStatus = "b"
+				}
+			}
+		case "allow", "deny":
+			// Apache 2.2: Deny-Einträge werden geblockt, Allow-Einträge
+			// gewhitelistet - unabhängig vom Status der Liste.
+			lineStatus = "w"
+			if strings.ToLower(fields[0]) == "deny" {
+				lineStatus = "b"
+			}
+			if len(fields) < 2 || strings.ToLower(fields[1]) != "from" {
+				unparsed = append(unparsed, fmt.Sprintf("Zeile %d: %s", lineNo, line))
+				continue
+			}
+			for _, host := range fields[2:] {
+				// "all" und "env=..." sind keine Adressen
+				if strings.ToLower(host) == "all" || strings.Contains(host, "=") {
+					continue
+				}
+				hosts = append(hosts, host)
+			}
+		case "order", "satisfy":
+			// Apache 2.2: Order und Satisfy enthalten keine Adressen
+			continue
+		default:
+			// ohne Status würde der Eintrag nie exportiert
+			if lineStatus == "" {
+				lineStatus = "b"
+			}
+			if helpers.StartsWithIPRange(line) {
+				// Bereich wie "203.0.113.7 - 203.0.114.200" aus Abuse-Meldungen oder RIR-Daten
+				start, end, err := helpers.ParseIPRange(line)
+				if err != nil {
+					unparsed = append(unparsed, fmt.Sprintf("Zeile %d: %s", lineNo, line))
+					continue
+				}
+				group++
+				for _, cidr := range helpers.RangeToCIDRs(start, end) {
+					entries = append(entries, ConfEntry{CIDR: cidr, Comment: comment, Status: lineStatus, Group: group})
+				}
+				continue
 			}
+			if !helpers.StartsWithIP(line) {
+				continue
+			}
+			// einfache IP-Liste: die Adresse steht am Zeilenanfang
+			hosts = fields[:1]
 		}
-		err := db.InsertPoollistEntry(database, cidr, poolName, comment, status)
-		if err != nil {
-			return fmt.Errorf("Fehler beim Import von %s: %w", cidr, err)
+
+		for _, host := range hosts {
+			cidr, err := helpers.ApacheHostToCIDR(host)
+			if err != nil {
+				unparsed = append(unparsed, fmt.Sprintf("Zeile %d: %s", lineNo, host))
+				continue
+			}
+			entries = append(entries, ConfEntry{CIDR: cidr, Comment: comment, Status: lineStatus})
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return err
+		return nil, nil, err
 	}
-	return nil
+	return entries, unparsed, nil
 }
 
 func GetStatusCount(entries []db.PoolEntry) (wCount int, bCount int) {
@@ -67,6 +189,21 @@ func GetStatusCount(entries []db.PoolEntry) (wCount int, bCount int) {
 	return wCount, bCount
 }
 
+// FormatDirective liefert die Apache-Direktive für einen Eintrag. Mit
+// legacyExport wird die Apache 2.2 Syntax (Allow/Deny from) verwendet.
+func FormatDirective(status, cidr string) string {
+	if app.Config.LegacyExport {
+		if status == "w" {
+			return "Allow from " + cidr
+		}
+		return "Deny from " + cidr
+	}
+	if status == "w" {
+		return "Require ip " + cidr
+	}
+	return "Require not ip " + cidr
+}
+
 func ExportConf(database *sql.DB, poolName, outputPath string) (wExported int, bExported int, err error) {
 	entries, _ := db.ListByPool(database, poolName)
 	wCount, bCount := GetStatusCount(entries)
@@ -96,9 +233,9 @@ func ExportConf(database *sql.DB, poolName, outputPath string) (wExported int, b
 					if len(comment) > 60 {
 						comment = comment[:60]
 					}
-					fmt.Fprintf(w, "Require ip %s # %s\n", e.CIDR, comment)
+					fmt.Fprintf(w, "%s # %s\n", FormatDirective("w", e.CIDR), comment)
 				} else {
-					fmt.Fprintf(w, "Require ip %s\n", e.CIDR)
+					fmt.Fprintln(w, FormatDirective("w", e.CIDR))
 				}
 				wExported++
 			}
@@ -127,9 +264,9 @@ func ExportConf(database *sql.DB, poolName, outputPath string) (wExported int, b
 					if len(comment) > 60 {
 						comment = comment[:60]
 					}
-					fmt.Fprintf(w, "Require not ip %s # %s\n", e.CIDR, comment)
+					fmt.Fprintf(w, "%s # %s\n", FormatDirective("b", e.CIDR), comment)
 				} else {
-					fmt.Fprintf(w, "Require not ip %s\n", e.CIDR)
+					fmt.Fprintln(w, FormatDirective("b", e.CIDR))
 				}
 				bExported++
 			}
@@ -140,40 +277,18 @@ func ExportConf(database *sql.DB, poolName, outputPath string) (wExported int, b
 }
 
 func InitDB(database *sql.DB) error {
-	err := db.CreateTables(database)
+	err := db.MigrateTables(database)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Anlegen der Datenbank: %v", err))
 	}
 	return err
 }
 
-func ExportDB2Conf(database *sql.DB) error {
-	today := time.Now().Format("2006-01-02")
-
-	// aktuelle WhiteListen sichern:
-	err := helpers.BackupFiles(app.Config.ListPath+"whitelists/", ".conf", app.Config.ListPath+"whitelists/"+today)
-	if err != nil {
-		app.LogIt.Error("Keine Dateien Exportiert, weil kein Backup erstellt werden konnte")
-		return err
-	}
-	// aktuelle BlockListen sichern:
-	err = helpers.BackupFiles(app.Config.ListPath+"blocklists/", ".conf", app.Config.ListPath+"blocklists/"+today)
-	if err != nil {
-		app.LogIt.Error("Keine Dateien Exportiert, weil kein Backup erstellt werden konnte")
-		return err
-	}
-	err = ExportDB(database, app.Config.ListPath)
-	if err != nil {
-		app.LogIt.Error(fmt.Sprintf("Fehler beim Export der Datenbank: %v", err))
+func ResetDB(database *sql.DB) error {
+	if _, err := BackupDB(database); err != nil {
+		app.LogIt.Error(fmt.Sprintf("Reset abgebrochen, weil die Datenbank nicht gesichert werden konnte: %v", err))
 		return err
 	}
-	fmt.Println("Konfigurationen aus der DB in die listen geschrieben.")
-	app.LogIt.Info("Konfigurationen aus der DB in die listen geschrieben.")
-	fmt.Println("der Webserver muss neu geladen werden (systemctl reload apache2)")
-	return nil
-}
-
-func ResetDB(database *sql.DB) error {
 	err := ExportDB(database, app.Config.BackupPath)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Putzen der Datenbank: %v", err))
@@ -184,7 +299,7 @@ func ResetDB(database *sql.DB) error {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Putzen der Datenbank: %v", err))
 		return err
 	}
-	err = db.CreateTables(database)
+	err = db.MigrateTables(database)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Anlegen der Datenbank: %v", err))
 		return err
@@ -197,6 +312,11 @@ func ResetDB(database *sql.DB) error {
 }
 
 func ExportDB(database *sql.DB, outputPath string) error {
+	for _, dir := range []string{"whitelists", "blocklists"} {
+		if err := os.MkdirAll(outputPath+dir, 0o750); err != nil {
+			return err
+		}
+	}
 	pools, err := db.ListPoolNames(database)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen der Pools: %v", err))
@@ -217,21 +337,26 @@ func ExportDB(database *sql.DB, outputPath string) error {
 
 func LoadApacheLists(database *sql.DB) error {
 	app.LogIt.Debug("LoadApacheLists")
+	return LoadListsFrom(database, app.Config.ListPath+"whitelists/", app.Config.ListPath+"blocklists/")
+}
 
-	entries, err := os.ReadDir(app.Config.ListPath + "blocklists/")
+// LoadListsFrom importiert die Whitelisten aus whitelistDir und die
+// Blocklisten aus blocklistDir
+func LoadListsFrom(database *sql.DB, whitelistDir, blocklistDir string) error {
+	entries, err := os.ReadDir(blocklistDir)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen der ApacheBlocklisten: %v", err))
 	}
-	err = LoadConfigs(database, entries, app.Config.ListPath+"blocklists/", "b")
+	err = LoadConfigs(database, entries, blocklistDir, "b")
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen der ApacheBlocklisten: %v", err))
 	}
 
-	entries, err = os.ReadDir(app.Config.ListPath + "whitelists/")
+	entries, err = os.ReadDir(whitelistDir)
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen der ApacheWhitelisten: %v", err))
 	}
-	err = LoadConfigs(database, entries, app.Config.ListPath+"whitelists/", "w")
+	err = LoadConfigs(database, entries, whitelistDir, "w")
 	if err != nil {
 		app.LogIt.Error(fmt.Sprintf("Fehler beim Lesen der ApacheWhitelisten: %v", err))
 	}
@@ -250,7 +375,10 @@ func LoadConfigs(database *sql.DB, entries []os.DirEntry, filesPath, status stri
 				app.LogIt.Info("lade " + conf.Name())
 				fmt.Println("lade", conf.Name())
 				poolName := strings.TrimSuffix(conf.Name(), filepath.Ext(conf.Name()))
-				ImportConf(database, file, poolName, status)
+				if _, err := ImportConf(database, file, poolName, status); err != nil {
+					app.LogIt.Error(fmt.Sprintf("Fehler beim Import von %s: %v", conf.Name(), err))
+				}
+				file.Close()
 			}
 		}
 	}
@@ -0,0 +1,194 @@
+package functions
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// testDB legt eine leere Datenbank im Testverzeichnis an. Die Konfiguration
+// wird nach dem Test zurückgesetzt, damit Tests sie frei ändern können.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	app.LogIt = slog.New(slog.NewTextHandler(io.Discard, nil))
+	saved := app.Config
+	t.Cleanup(func() { app.Config = saved })
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "blv.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := db.MigrateTables(database); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func TestParseConf(t *testing.T) {
+	conf := `# Kopfkommentar
+<RequireAll>
+Require all granted
+Require not ip 192.0.2.1 192.0.2.0/255.255.255.0 10.1. # mehrere Adressen
+Require ip 172.16 # partiell
+Require not ip 300.1.1.1
+Order allow,deny
+Allow from 198.51.100.0/24 all
+Deny from 203.0.113.5 env=bad
+Deny 203.0.113.6
+Satisfy any
+198.18.0.1 # einfache Liste
+198.18.0.8 - 198.18.0.10 # Bereich
+198.18.1.0-198.18.0.255
+0.0.0.0-255.255.255.255
+</RequireAll>
+`
+	entries, unparsed, err := ParseConf(strings.NewReader(conf), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Status+" "+e.CIDR+" "+e.Comment)
+	}
+	want := []string{
+		"b 192.0.2.1/32 mehrere Adressen",
+		"b 192.0.2.0/24 mehrere Adressen",
+		"b 10.1.0.0/16 mehrere Adressen",
+		"w 172.16.0.0/16 partiell",
+		"w 198.51.100.0/24 ",
+		"b 203.0.113.5/32 ",
+		"b 198.18.0.1/32 einfache Liste",
+		"b 198.18.0.8/31 Bereich",
+		"b 198.18.0.10/32 Bereich",
+		"b 0.0.0.0/0 ",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseConf:\n%s\nerwartet:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	wantUnparsed := []string{
+		"Zeile 6: 300.1.1.1",
+		"Zeile 10: Deny 203.0.113.6",
+		"Zeile 14: 198.18.1.0-198.18.0.255",
+	}
+	if !slices.Equal(unparsed, wantUnparsed) {
+		t.Errorf("ParseConf unparsed = %q, erwartet %q", unparsed, wantUnparsed)
+	}
+
+	// CIDRs eines Bereichs teilen sich eine Gruppe, andere Bereiche nicht
+	groups := map[string]int{}
+	for _, e := range entries {
+		groups[e.CIDR] = e.Group
+	}
+	if groups["198.18.0.8/31"] == 0 || groups["198.18.0.8/31"] != groups["198.18.0.10/32"] {
+		t.Errorf("Bereich 198.18.0.8 - 198.18.0.10 hat keine gemeinsame Gruppe: %v", groups)
+	}
+	if groups["0.0.0.0/0"] == groups["198.18.0.8/31"] || groups["192.0.2.1/32"] != 0 {
+		t.Errorf("falsche Gruppen: %v", groups)
+	}
+}
+
+func TestParseConfStatus(t *testing.T) {
+	// ein vorgegebener Status gilt für Require, nicht aber für Allow/Deny
+	conf := "Require ip 192.0.2.1\nRequire not ip 192.0.2.2\nAllow from 192.0.2.3\n192.0.2.4\n"
+	entries, _, err := ParseConf(strings.NewReader(conf), "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Status+" "+e.CIDR)
+	}
+	want := []string{"b 192.0.2.1/32", "b 192.0.2.2/32", "w 192.0.2.3/32", "b 192.0.2.4/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseConf mit Status b = %q, erwartet %q", got, want)
+	}
+}
+
+func TestImportConfWithoutStatus(t *testing.T) {
+	database := testDB(t)
+
+	// ohne Status werden IP-Listen und Bereiche geblockt, Direktiven behalten ihren Status
+	conf := "192.0.2.1\n203.0.113.7 - 203.0.113.9\nAllow from 198.51.100.1\n"
+	if _, err := ImportConf(database, strings.NewReader(conf), "api", ""); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.ListByPool(database, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Status+" "+e.CIDR)
+	}
+	slices.Sort(got)
+	want := []string{"b 192.0.2.1/32", "b 203.0.113.7/32", "b 203.0.113.8/31", "w 198.51.100.1/32"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Import ohne Status = %q, erwartet %q", got, want)
+	}
+}
+
+func TestInsertRangeEntryOverlap(t *testing.T) {
+	database := testDB(t)
+
+	if _, err := db.InsertEntry(database, "198.51.100.7", "alt", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	// der bestehende Eintrag liegt mitten im Bereich, nicht an dessen Rändern
+	found, err := db.InsertRangeEntry(database, []string{"198.51.100.0/24"}, "neu", "", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.CIDR != "198.51.100.7/32" {
+		t.Fatalf("InsertRangeEntry = %v, erwartet 198.51.100.7/32", found)
+	}
+	if entries, _ := db.ListByPool(database, "neu"); len(entries) != 0 {
+		t.Errorf("trotz Überschneidung angelegt: %v", entries)
+	}
+}
+
+func TestPoolNameChecked(t *testing.T) {
+	database := testDB(t)
+	const bad = "../etc"
+
+	if _, err := db.InsertEntry(database, "192.0.2.1", bad, "", "b"); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("InsertEntry: %v", err)
+	}
+	if _, err := db.InsertRangeEntry(database, []string{"192.0.2.2/31"}, bad, "", "b"); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("InsertRangeEntry: %v", err)
+	}
+	if _, err := ImportConf(database, strings.NewReader(""), bad, "b"); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("ImportConf: %v", err)
+	}
+	if err := db.ApplyChanges(database, []db.PoolEntry{{CIDR: "192.0.2.4/32", Name: bad}}, nil, nil); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("ApplyChanges: %v", err)
+	}
+	if err := db.ReplaceEntries(database, []db.PoolEntry{{CIDR: "192.0.2.5/32", Name: bad}}); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("ReplaceEntries: %v", err)
+	}
+
+	if _, err := db.InsertEntry(database, "198.51.100.1", "gut", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.ListByPool(database, "gut")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := BulkRequest{Pool: "gut", Action: BulkMove, IDs: []string{strconv.Itoa(entries[0].ID)}, Target: bad}
+	if _, err := BulkEntries(database, req); !errors.Is(err, helpers.ErrInvalidPoolName) {
+		t.Errorf("BulkEntries: %v", err)
+	}
+	if names, _ := db.ListPoolNames(database); !slices.Equal(names, []string{"gut"}) {
+		t.Errorf("Pools = %q, erwartet nur gut", names)
+	}
+}
@@ -0,0 +1,107 @@
+package functions
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// ExpectedListFiles liefert die Pools, für die ExportConf eine Whitelist bzw.
+// eine Blockliste schreibt.
+func ExpectedListFiles(database *sql.DB) (whitelists []string, blocklists []string, err error) {
+	whitelists, err = db.ListPoolNamesByStatus(database, "w")
+	if err != nil {
+		return nil, nil, err
+	}
+	blocklists, err = db.ListPoolNamesByStatus(database, "b")
+	if err != nil {
+		return nil, nil, err
+	}
+	return whitelists, blocklists, nil
+}
+
+// managedListFiles liefert die Listen (*.conf in whitelists/ und blocklists/)
+// im ListPath relativ zum ListPath
+func managedListFiles() ([]string, error) {
+	var files []string
+	for _, dir := range []string{"whitelists", "blocklists"} {
+		entries, err := os.ReadDir(app.Config.ListPath + dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && filepath.Ext(entry.Name()) == ".conf" {
+				files = append(files, dir+"/"+entry.Name())
+			}
+		}
+	}
+	return files, nil
+}
+
+// orphanedListFiles liefert die Listen im ListPath, die nicht unter files sind,
+// also von Pools stammen, die gelöscht wurden oder den Status gewechselt haben
+func orphanedListFiles(files []string) ([]string, error) {
+	managed, err := managedListFiles()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(managed, func(rel string) bool { return slices.Contains(files, rel) }), nil
+}
+
+// WriteMasterInclude schreibt nach outputPath die Datei, die ein vhost per
+// Include einbindet. Die Include-Zeilen verweisen auf die Listen in listPath.
+// Whitelists stehen in einem <RequireAny> und greifen damit sofort, die
+// Blocklisten werden in einem <RequireAll> mit "Require all granted"
+// kombiniert. Im legacyExport wird stattdessen Order Deny,Allow verwendet,
+// wodurch Allow from (Whitelists) Vorrang vor Deny from hat.
+func WriteMasterInclude(outputPath, listPath string, whitelists, blocklists []string) error {
+	absPath, err := filepath.Abs(listPath)
+	if err != nil {
+		return err
+	}
+	includeFile, err := os.Create(filepath.Join(outputPath, app.Config.MasterInclude))
+	if err != nil {
+		return fmt.Errorf("konnte Datei nicht erstellen: %w", err)
+	}
+	defer includeFile.Close()
+
+	w := bufio.NewWriter(includeFile)
+
+	fmt.Fprintln(w, "#----------------------------------------")
+	fmt.Fprintln(w, "# generiert von blv - nicht von Hand ändern")
+	fmt.Fprintln(w, "# im vhost: Include "+filepath.Join(absPath, app.Config.MasterInclude))
+	fmt.Fprintln(w, "#----------------------------------------")
+
+	if app.Config.LegacyExport {
+		fmt.Fprintln(w, "Order Deny,Allow")
+		for _, pool := range blocklists {
+			fmt.Fprintln(w, "Include "+filepath.Join(absPath, "blocklists", pool+".conf"))
+		}
+		for _, pool := range whitelists {
+			fmt.Fprintln(w, "Include "+filepath.Join(absPath, "whitelists", pool+".conf"))
+		}
+		return w.Flush()
+	}
+
+	fmt.Fprintln(w, "<RequireAny>")
+	for _, pool := range whitelists {
+		fmt.Fprintln(w, "    Include "+filepath.Join(absPath, "whitelists", pool+".conf"))
+	}
+	fmt.Fprintln(w, "    <RequireAll>")
+	fmt.Fprintln(w, "        Require all granted")
+	for _, pool := range blocklists {
+		fmt.Fprintln(w, "        Include "+filepath.Join(absPath, "blocklists", pool+".conf"))
+	}
+	fmt.Fprintln(w, "    </RequireAll>")
+	fmt.Fprintln(w, "</RequireAny>")
+	return w.Flush()
+}
@@ -0,0 +1,177 @@
+package functions
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// LDAPUser ist ein im Verzeichnis gefundener Benutzer mit der Rolle, die sich
+// aus seinen Gruppen ergibt. Ohne passende Gruppe ist Role leer.
+type LDAPUser struct {
+	DN     string
+	Groups []string
+	Role   string
+	Pools  []string
+}
+
+func ldapTLSConfig(cfg app.LDAPConfig) (*tls.Config, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ungültige LDAP-URL %q: %w", cfg.URL, err)
+	}
+	tlsCfg := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("keine Zertifikate in %s", cfg.CACert)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return tlsCfg, nil
+}
+
+// ldapConnect verbindet sich mit dem LDAP-Server und bindet sich für die
+// Suche mit BindDN
+func ldapConnect(cfg app.LDAPConfig) (*ldap.Conn, error) {
+	tlsCfg, err := ldapTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: cfg.Timeout}),
+		ldap.DialWithTLSConfig(tlsCfg))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP-Server %s nicht erreichbar: %w", cfg.URL, err)
+	}
+	conn.SetTimeout(cfg.Timeout)
+	if cfg.StartTLS {
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS mit %s fehlgeschlagen: %w", cfg.URL, err)
+		}
+	}
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Bind als %s fehlgeschlagen: %w", cfg.BindDN, err)
+		}
+	}
+	return conn, nil
+}
+
+// groupRole bestimmt die höchste Rolle aus den Gruppen. Gilt eine der
+// Zuordnungen mit dieser Rolle für alle Pools, gilt sie überall, sonst für
+// alle aufgeführten Pools.
+func groupRole(groupRoles []app.GroupRole, groups []string) (string, []string) {
+	role, allPools := "", false
+	var pools []string
+	for _, gr := range groupRoles {
+		if !slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, gr.Group) }) {
+			continue
+		}
+		switch rank, current := slices.Index(Roles, gr.Role), slices.Index(Roles, role); {
+		case rank < 0 || rank < current:
+			continue
+		case rank > current:
+			role, allPools, pools = gr.Role, false, nil
+		}
+		if len(gr.Pools) == 0 {
+			allPools = true
+		}
+		for _, p := range gr.Pools {
+			if !slices.Contains(pools, p) {
+				pools = append(pools, p)
+			}
+		}
+	}
+	if allPools {
+		pools = nil
+	}
+	return role, pools
+}
+
+// LDAPAuthenticate sucht den Benutzer im Verzeichnis und prüft sein Passwort
+// per Bind. Bei unbekanntem Benutzer oder falschem Passwort wird nil geliefert.
+func LDAPAuthenticate(name, password string) (*LDAPUser, error) {
+	cfg := app.Config.LDAP
+	// ein Bind ohne Passwort gilt bei vielen Servern als anonym und gelingt
+	if name == "" || password == "" {
+		return nil, nil
+	}
+	conn, err := ldapConnect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(cfg.UserFilter, ldap.EscapeFilter(name)),
+		[]string{cfg.GroupAttribute}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP-Suche nach %s fehlgeschlagen: %w", name, err)
+	}
+	if len(res.Entries) != 1 {
+		if len(res.Entries) > 1 {
+			app.LogIt.Warn(fmt.Sprintf("LDAP-Suche nach %s liefert mehrere Einträge", name))
+		}
+		return nil, nil
+	}
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("LDAP-Bind als %s fehlgeschlagen: %w", entry.DN, err)
+	}
+	user := &LDAPUser{DN: entry.DN, Groups: entry.GetAttributeValues(cfg.GroupAttribute)}
+	user.Role, user.Pools = groupRole(cfg.GroupRoles, user.Groups)
+	return user, nil
+}
+
+// ldapLogin meldet einen Benutzer über LDAP an und hält ihn mit der Rolle aus
+// seinen Gruppen in der Datenbank fest
+func ldapLogin(database *sql.DB, name, password string) (*Account, error) {
+	user, err := LDAPAuthenticate(name, password)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if user.Role == "" {
+		app.LogIt.Info(fmt.Sprintf("LDAP-Benutzer %s ist in keiner Gruppe mit einer Rolle", name))
+		return nil, nil
+	}
+	now := time.Now().Format(timestampLayout)
+	err = db.SaveExternalUser(database, &db.User{
+		Username:  name,
+		Role:      user.Role,
+		Pools:     user.Pools,
+		Source:    SourceLDAP,
+		LastLogin: now,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Account{Name: name, Role: user.Role, Pools: user.Pools, Source: SourceLDAP}, nil
+}
@@ -0,0 +1,346 @@
+package functions
+
+import (
+	"database/sql"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+func TestGroupRole(t *testing.T) {
+	groupRoles := []app.GroupRole{
+		{Group: "cn=helpdesk", Role: RoleViewer},
+		{Group: "cn=netops", Role: RoleEditor, Pools: []string{"netz"}},
+		{Group: "cn=web", Role: RoleEditor, Pools: []string{"web", "netz"}},
+		{Group: "cn=security", Role: RoleEditor},
+		{Group: "cn=admins", Role: RoleAdmin},
+		{Group: "cn=kaputt", Role: "superuser"},
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		role   string
+		pools  []string
+	}{
+		{name: "keine Gruppe", groups: nil},
+		{name: "fremde Gruppe", groups: []string{"cn=andere"}},
+		{name: "unbekannte Rolle", groups: []string{"cn=kaputt"}},
+		{name: "Gross-/Kleinschreibung", groups: []string{"CN=Helpdesk"}, role: RoleViewer},
+		{name: "Pools", groups: []string{"cn=netops"}, role: RoleEditor, pools: []string{"netz"}},
+		{name: "Pools mehrerer Gruppen", groups: []string{"cn=web", "cn=netops"}, role: RoleEditor, pools: []string{"netz", "web"}},
+		{name: "alle Pools gehen vor", groups: []string{"cn=netops", "cn=security"}, role: RoleEditor},
+		{name: "höhere Rolle zuerst", groups: []string{"cn=admins", "cn=netops"}, role: RoleAdmin},
+		{name: "höhere Rolle danach", groups: []string{"cn=helpdesk", "cn=netops"}, role: RoleEditor, pools: []string{"netz"}},
+		{name: "tiefere Rolle ändert nichts", groups: []string{"cn=netops", "cn=helpdesk", "cn=kaputt"}, role: RoleEditor, pools: []string{"netz"}},
+	}
+	for _, tt := range tests {
+		role, pools := groupRole(groupRoles, tt.groups)
+		if role != tt.role || !slices.Equal(pools, tt.pools) {
+			t.Errorf("%s: groupRole = %q %q, erwartet %q %q", tt.name, role, pools, tt.role, tt.pools)
+		}
+	}
+}
+
+// ldapEntry ist ein Benutzer im ldapServer
+type ldapEntry struct {
+	dn       string
+	password string
+	groups   []string
+}
+
+// ldapServer ist ein minimaler LDAP-Server für Bind und Suche. Ein Eintrag
+// wird nur gefunden, wenn der Filter genau (uid=<Name>) lautet.
+type ldapServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	users    map[string]ldapEntry
+	filters  []string
+	binds    int
+}
+
+const (
+	ldapBindDN       = "cn=blv,dc=example,dc=org"
+	ldapBindPassword = "dienstkonto"
+)
+
+func newLDAPServer(t *testing.T, users map[string]ldapEntry) *ldapServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapServer{listener: l, users: users}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *ldapServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// requests liefert die Anzahl Binds und die Filter der Suchen
+func (s *ldapServer) requests() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.binds, slices.Clone(s.filters)
+}
+
+func (s *ldapServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := s.bind(op.Children[1].Value.(string), op.Children[2].Data.String())
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			for _, entry := range s.search(op.Children[6]) {
+				conn.Write(ldapMessage(id, entry).Bytes())
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapServer) bind(dn, password string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.binds++
+	if dn == ldapBindDN && password == ldapBindPassword {
+		return ldap.LDAPResultSuccess
+	}
+	for _, u := range s.users {
+		if u.dn == dn && u.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (s *ldapServer) search(filterPacket *ber.Packet) []*ber.Packet {
+	filter, err := ldap.DecompileFilter(filterPacket)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = append(s.filters, filter)
+	var entries []*ber.Packet
+	for name, u := range s.users {
+		if filter != "(uid="+ldap.EscapeFilter(name)+")" {
+			continue
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, u.dn, ""))
+		attrs := ber.NewSequence("")
+		attr := ber.NewSequence("")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, g := range u.groups {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, g, ""))
+		}
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+		entry.AppendChild(attrs)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func ldapMessage(id any, op *ber.Packet) *ber.Packet {
+	msg := ber.NewSequence("")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	msg.AppendChild(op)
+	return msg
+}
+
+func ldapResponse(id any, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+// ldapTest richtet Datenbank, LDAP-Server und Konfiguration ein und leert den
+// Anmelde-Cache
+func ldapTest(t *testing.T, users map[string]ldapEntry) (*sql.DB, *ldapServer, func(name, password string) *Account) {
+	t.Helper()
+	database := testDB(t)
+	srv := newLDAPServer(t, users)
+	app.Config.LDAP = app.LDAPConfig{
+		Enabled:        true,
+		URL:            srv.url(),
+		BindDN:         ldapBindDN,
+		BindPassword:   ldapBindPassword,
+		BaseDN:         "dc=example,dc=org",
+		UserFilter:     "(uid=%s)",
+		GroupAttribute: "memberOf",
+		GroupRoles: []app.GroupRole{
+			{Group: "cn=netops,dc=example,dc=org", Role: RoleEditor, Pools: []string{"netz"}},
+		},
+		Timeout: 5 * time.Second,
+	}
+	clearAuthCache := func() {
+		authCacheMu.Lock()
+		clear(authCache)
+		authCacheMu.Unlock()
+	}
+	clearAuthCache()
+	t.Cleanup(clearAuthCache)
+
+	login := func(name, password string) *Account {
+		t.Helper()
+		account, err := AuthenticateUser(database, name, password)
+		if err != nil {
+			t.Fatalf("AuthenticateUser(%s): %v", name, err)
+		}
+		return account
+	}
+	return database, srv, login
+}
+
+func TestAuthenticateUserLDAP(t *testing.T) {
+	_, srv, login := ldapTest(t, map[string]ldapEntry{
+		"lisa":     {dn: "uid=lisa,dc=example,dc=org", password: "lisapasswort", groups: []string{"cn=netops,dc=example,dc=org"}},
+		"otto":     {dn: "uid=otto,dc=example,dc=org", password: "ottopasswort"},
+		"a*)(uid=": {dn: "uid=fies,dc=example,dc=org", password: "fiespasswort", groups: []string{"cn=netops,dc=example,dc=org"}},
+	})
+
+	account := login("lisa", "lisapasswort")
+	if account == nil || account.Role != RoleEditor || !slices.Equal(account.Pools, []string{"netz"}) || account.Source != SourceLDAP {
+		t.Fatalf("Anmeldung lisa = %+v", account)
+	}
+	if login("lisa", "falsch") != nil {
+		t.Error("Anmeldung mit falschem Passwort gelungen")
+	}
+	if login("otto", "ottopasswort") != nil {
+		t.Error("Anmeldung ohne Gruppe mit Rolle gelungen")
+	}
+	if login("niemand", "irgendwas") != nil {
+		t.Error("Anmeldung eines unbekannten Benutzers gelungen")
+	}
+	if login("lisa", "") != nil {
+		t.Error("Anmeldung ohne Passwort gelungen")
+	}
+
+	// Sonderzeichen im Namen werden im Filter maskiert
+	if login("a*)(uid=", "fiespasswort") == nil {
+		t.Error("Anmeldung mit Sonderzeichen im Namen fehlgeschlagen")
+	}
+	if login("*", "lisapasswort") != nil {
+		t.Error("Anmeldung mit * als Namen gelungen")
+	}
+	_, filters := srv.requests()
+	for _, want := range []string{`(uid=a\2a\29\28uid=)`, `(uid=\2a)`} {
+		if !slices.Contains(filters, want) {
+			t.Errorf("Filter %s nicht gesucht, gesucht: %q", want, filters)
+		}
+	}
+}
+
+func TestAuthenticateUserLocalFirst(t *testing.T) {
+	database, srv, login := ldapTest(t, map[string]ldapEntry{
+		"notfall": {dn: "uid=notfall,dc=example,dc=org", password: "ldappasswort", groups: []string{"cn=netops,dc=example,dc=org"}},
+		"lisa":    {dn: "uid=lisa,dc=example,dc=org", password: "lisapasswort", groups: []string{"cn=netops,dc=example,dc=org"}},
+	})
+	if err := AddUser(database, "notfall", "lokalpasswort", RoleAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// ein lokaler Benutzer wird nie gegen LDAP geprüft
+	if login("notfall", "ldappasswort") != nil {
+		t.Error("lokaler Benutzer mit LDAP-Passwort angemeldet")
+	}
+	if account := login("notfall", "lokalpasswort"); account == nil || account.Role != RoleAdmin || account.Source != SourceLocal {
+		t.Errorf("Anmeldung des lokalen Benutzers = %+v", account)
+	}
+	if binds, filters := srv.requests(); binds != 0 || len(filters) != 0 {
+		t.Errorf("LDAP für den lokalen Benutzer gefragt: %d Binds, Filter %q", binds, filters)
+	}
+
+	// ein LDAP-Benutzer in der Datenbank wird weiter gegen LDAP geprüft
+	if login("lisa", "lisapasswort") == nil {
+		t.Fatal("Anmeldung lisa fehlgeschlagen")
+	}
+	if u, err := db.GetUser(database, "lisa"); err != nil || u == nil || u.Source != SourceLDAP {
+		t.Fatalf("lisa in der Datenbank = %+v, %v", u, err)
+	}
+	clear(authCache)
+	if login("lisa", "lisapasswort") == nil {
+		t.Error("zweite Anmeldung lisa fehlgeschlagen")
+	}
+
+	// ohne erreichbaren LDAP-Server bleibt die lokale Anmeldung möglich
+	srv.listener.Close()
+	clear(authCache)
+	if login("notfall", "lokalpasswort") == nil {
+		t.Error("lokale Anmeldung ohne LDAP-Server fehlgeschlagen")
+	}
+	if _, err := AuthenticateUser(database, "lisa", "lisapasswort"); err == nil {
+		t.Error("Anmeldung lisa ohne LDAP-Server ohne Fehler")
+	}
+}
+
+func TestAuthenticateUserCache(t *testing.T) {
+	_, srv, login := ldapTest(t, map[string]ldapEntry{
+		"lisa": {dn: "uid=lisa,dc=example,dc=org", password: "lisapasswort", groups: []string{"cn=netops,dc=example,dc=org"}},
+	})
+
+	if login("lisa", "lisapasswort") == nil {
+		t.Fatal("Anmeldung lisa fehlgeschlagen")
+	}
+	binds, _ := srv.requests()
+	if login("lisa", "lisapasswort") == nil {
+		t.Fatal("zweite Anmeldung lisa fehlgeschlagen")
+	}
+	if again, _ := srv.requests(); again != binds {
+		t.Errorf("zweite Anmeldung innerhalb von %s fragt LDAP erneut", authCacheTTL)
+	}
+
+	// ein anderes Passwort geht am Cache vorbei
+	if login("lisa", "falsch") != nil {
+		t.Error("Anmeldung mit falschem Passwort gelungen")
+	}
+	if again, _ := srv.requests(); again == binds {
+		t.Error("falsches Passwort aus dem Cache beantwortet")
+	}
+
+	// nach Ablauf wird wieder gegen LDAP geprüft
+	binds, _ = srv.requests()
+	authCacheMu.Lock()
+	entry := authCache["lisa"]
+	entry.until = time.Now().Add(-time.Second)
+	authCache["lisa"] = entry
+	authCacheMu.Unlock()
+	if login("lisa", "lisapasswort") == nil {
+		t.Fatal("Anmeldung nach Ablauf fehlgeschlagen")
+	}
+	if again, _ := srv.requests(); again == binds {
+		t.Error("abgelaufener Cache-Eintrag verwendet")
+	}
+}
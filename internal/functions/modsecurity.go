@@ -0,0 +1,148 @@
+package functions
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// Anzahl Regel-IDs ab modSecurity.idBase
+const modSecIDSpan = 10000
+
+// modSecListName ist der Name der ipMatchFromFile-Liste eines Pools
+func modSecListName(data ExportData) string {
+	if data.Status == "w" {
+		return "whitelist-" + data.Pool + ".txt"
+	}
+	return "blocklist-" + data.Pool + ".txt"
+}
+
+// modSecRuleKey ist der Name der Liste ohne Endung, z.B. blocklist-scanner.
+// Unter diesem Namen kann in modSecurity.ruleIds eine eigene ID festgelegt werden.
+func modSecRuleKey(data ExportData) string {
+	return strings.TrimSuffix(modSecListName(data), ".txt")
+}
+
+// modSecRuleIDs vergibt die Regel-IDs und hält sie in der Datenbank fest,
+// damit die ID einer Liste über Aktivierungen hinweg gleich bleibt, auch wenn
+// Pools dazukommen oder wegfallen
+type modSecRuleIDs struct {
+	database *sql.DB
+	assigned map[string]int // Liste -> Abstand zu idBase
+	owner    map[int]string // Abstand zu idBase -> Liste
+	used     map[int]string // in dieser Regeldatei vergebene IDs -> Liste
+}
+
+func loadModSecRuleIDs(database *sql.DB) (*modSecRuleIDs, error) {
+	stored, err := db.ListModSecRuleIDs(database)
+	if err != nil {
+		return nil, err
+	}
+	ids := &modSecRuleIDs{database: database, assigned: map[string]int{}, owner: map[int]string{}, used: map[int]string{}}
+	for _, id := range stored {
+		key := modSecRuleKey(ExportData{Pool: id.Pool, Status: id.Status})
+		ids.assigned[key] = id.Offset
+		ids.owner[id.Offset] = key
+	}
+	return ids, nil
+}
+
+// ruleID liefert die Regel-ID einer Liste: die in modSecurity.ruleIds
+// festgelegte, sonst die festgehaltene. Eine neue Liste erhält die aus Pool
+// und Status abgeleitete ID (FNV-Hash). Ist diese bereits vergeben, ist das
+// ein Fehler, die Liste braucht dann eine eigene ID in modSecurity.ruleIds.
+func (ids *modSecRuleIDs) ruleID(data ExportData) (int, error) {
+	cfg := app.Config.ModSecurity
+	key := modSecRuleKey(data)
+	id, explicit := cfg.RuleIDs[key]
+	if !explicit {
+		offset, ok := ids.assigned[key]
+		if !ok {
+			h := fnv.New32a()
+			h.Write([]byte(data.Status + "/" + data.Pool))
+			offset = int(h.Sum32() % modSecIDSpan)
+			if other, taken := ids.owner[offset]; taken {
+				return 0, fmt.Errorf("ModSecurity: Regel-ID %d für %s ist bereits von %s belegt, bitte in modSecurity.ruleIds eine eigene ID für %s festlegen", cfg.IDBase+offset, key, other, key)
+			}
+			if err := db.InsertModSecRuleID(ids.database, db.ModSecRuleID{Pool: data.Pool, Status: data.Status, Offset: offset}); err != nil {
+				return 0, fmt.Errorf("ModSecurity: Regel-ID für %s nicht festgehalten: %w", key, err)
+			}
+			ids.assigned[key] = offset
+			ids.owner[offset] = key
+			app.LogIt.Info(fmt.Sprintf("ModSecurity: Regel-ID %d für %s vergeben", cfg.IDBase+offset, key))
+		}
+		id = cfg.IDBase + offset
+	}
+	if other, taken := ids.used[id]; taken {
+		return 0, fmt.Errorf("ModSecurity: Regel-ID %d für %s ist bereits von %s belegt", id, key, other)
+	}
+	ids.used[id] = key
+	return id, nil
+}
+
+// renderModSecurity erzeugt je Pool und Status eine Liste für @ipMatchFromFile
+// und eine Regeldatei mit einer SecRule je Liste. Whitelists stehen zuerst,
+// damit deren Aktionen vor den Blocklisten greifen.
+func renderModSecurity(database *sql.DB, exports []ExportData) ([]exportFile, error) {
+	cfg := app.Config.ModSecurity
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	ids, err := loadModSecRuleIDs(database)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(cfg.OutputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []exportFile
+	var rules bytes.Buffer
+	fmt.Fprintln(&rules, "#----------------------------------------")
+	fmt.Fprintln(&rules, "# generiert von blv - nicht von Hand ändern")
+	fmt.Fprintln(&rules, "# Include "+filepath.Join(absDir, cfg.RulesFile))
+	fmt.Fprintln(&rules, "#----------------------------------------")
+
+	for _, status := range []string{"w", "b"} {
+		for _, data := range exports {
+			if data.Status != status {
+				continue
+			}
+			var list bytes.Buffer
+			for _, e := range data.Entries {
+				fmt.Fprintln(&list, e.CIDR)
+			}
+			listName := modSecListName(data)
+			files = append(files, exportFile{Path: cfg.OutputDir + listName, Content: list.Bytes()})
+
+			actions := cfg.BlockActions
+			kind := "Blockliste"
+			if status == "w" {
+				actions = cfg.WhitelistActions
+				kind = "Whitelist"
+			}
+			if poolActions, ok := cfg.PoolActions[data.Pool]; ok {
+				actions = poolActions
+			}
+			id, err := ids.ruleID(data)
+			if err != nil {
+				return nil, err
+			}
+			ruleActions := fmt.Sprintf("id:%d,phase:%d,%s", id, cfg.Phase, actions)
+			if !strings.Contains(actions, "msg:") {
+				ruleActions += fmt.Sprintf(",msg:'blv %s %s'", kind, strings.ReplaceAll(data.Pool, "'", ""))
+			}
+			fmt.Fprintf(&rules, "SecRule %s \"@ipMatchFromFile %s\" \\\n    \"%s\"\n",
+				cfg.Variable, filepath.Join(absDir, listName), ruleActions)
+		}
+	}
+	files = append(files, exportFile{Path: cfg.OutputDir + cfg.RulesFile, Content: rules.Bytes()})
+	return files, nil
+}
@@ -0,0 +1,127 @@
+package functions
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// ruleLine findet in der Regeldatei Liste und Regel-ID einer SecRule
+var ruleLine = regexp.MustCompile(`((?:white|block)list-[\w-]+)\.txt" \\\n    "id:(\d+),`)
+
+// modSecIDs rendert die ModSecurity-Exporte und liefert die Regel-ID je Liste
+func modSecIDs(t *testing.T, database *sql.DB) (map[string]int, error) {
+	t.Helper()
+	exports, err := poolExports(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := renderModSecurity(database, exports)
+	if err != nil {
+		return nil, err
+	}
+	rules := string(files[len(files)-1].Content)
+	ids := map[string]int{}
+	for _, m := range ruleLine.FindAllStringSubmatch(rules, -1) {
+		ids[m[1]], _ = strconv.Atoi(m[2])
+	}
+	return ids, nil
+}
+
+func TestModSecRuleIDsStable(t *testing.T) {
+	database := testDB(t)
+	app.Config.ModSecurity = app.ModSecurityConfig{Enabled: true, OutputDir: t.TempDir() + "/", RulesFile: "blv.conf", IDBase: 10000, Phase: 1, Variable: "REMOTE_ADDR"}
+
+	for _, e := range []struct{ cidr, pool, status string }{
+		{"192.0.2.1", "scanner", "b"}, {"192.0.2.2", "partner", "w"}, {"192.0.2.3", "partner", "b"},
+	} {
+		if _, err := db.InsertEntry(database, e.cidr, e.pool, "", e.status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, err := modSecIDs(t, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 3 {
+		t.Fatalf("Regel-IDs = %v, erwartet drei Listen", first)
+	}
+
+	// neue und gelöschte Pools verschieben die übrigen IDs nicht
+	if err := db.DeletePoolStatus(database, "partner", "w"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pool := range []string{"aaa", "zzz"} {
+		if _, err := db.InsertEntry(database, "198.51.100.1", pool, "", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second, err := modSecIDs(t, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"blocklist-scanner", "blocklist-partner"} {
+		if second[key] != first[key] {
+			t.Errorf("Regel-ID von %s: %d, vorher %d", key, second[key], first[key])
+		}
+	}
+
+	// ein wieder angelegter Pool erhält seine alte ID
+	if _, err := db.InsertEntry(database, "192.0.2.2", "partner", "", "w"); err != nil {
+		t.Fatal(err)
+	}
+	third, err := modSecIDs(t, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := maps.Clone(second)
+	want["whitelist-partner"] = first["whitelist-partner"]
+	if !maps.Equal(third, want) {
+		t.Errorf("Regel-IDs = %v, erwartet %v", third, want)
+	}
+}
+
+func TestModSecRuleIDCollision(t *testing.T) {
+	database := testDB(t)
+	app.Config.ModSecurity = app.ModSecurityConfig{Enabled: true, OutputDir: t.TempDir() + "/", RulesFile: "blv.conf", IDBase: 10000, Phase: 1, Variable: "REMOTE_ADDR"}
+
+	// die abgeleitete ID von blocklist-neu ist bereits an einen anderen Pool vergeben
+	h := fnv.New32a()
+	h.Write([]byte("b/neu"))
+	offset := int(h.Sum32() % modSecIDSpan)
+	if err := db.InsertModSecRuleID(database, db.ModSecRuleID{Pool: "alt", Status: "b", Offset: offset}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertEntry(database, "192.0.2.1", "neu", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := modSecIDs(t, database); err == nil || !strings.Contains(err.Error(), "ruleIds") {
+		t.Fatalf("Kollision: %v, erwartet Fehler mit Hinweis auf ruleIds", err)
+	}
+
+	// mit einer eigenen ID geht es
+	app.Config.ModSecurity.RuleIDs = map[string]int{"blocklist-neu": 19999}
+	ids, err := modSecIDs(t, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids["blocklist-neu"] != 19999 {
+		t.Errorf("Regel-IDs = %v, erwartet blocklist-neu 19999", ids)
+	}
+
+	// eine eigene ID, die eine andere Liste bereits hat, ist ein Fehler
+	if _, err := db.InsertEntry(database, "192.0.2.2", "alt", "", "b"); err != nil {
+		t.Fatal(err)
+	}
+	app.Config.ModSecurity.RuleIDs = map[string]int{"blocklist-neu": 10000 + offset}
+	if _, err := modSecIDs(t, database); err == nil {
+		t.Error("doppelte Regel-ID ohne Fehler")
+	}
+}
@@ -0,0 +1,201 @@
+package functions
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// OIDCLoginTimeout begrenzt die Dauer einer Anmeldung beim Identity Provider
+const OIDCLoginTimeout = 10 * time.Minute
+
+// oidcLogin ist eine begonnene Anmeldung, die auf den Rücksprung vom
+// Identity Provider wartet
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+var (
+	oidcMu       sync.Mutex
+	oidcProvider *oidc.Provider
+	oidcPending  = map[string]oidcLogin{}
+)
+
+func oidcContext() (context.Context, context.CancelFunc) {
+	timeout := app.Config.OIDC.Timeout
+	ctx := oidc.ClientContext(context.Background(), &http.Client{Timeout: timeout})
+	return context.WithTimeout(ctx, timeout)
+}
+
+// oidcSetup liest die Discovery des Identity Providers beim ersten Bedarf und
+// liefert die OAuth2-Konfiguration dazu
+func oidcSetup(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	cfg := app.Config.OIDC
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcProvider == nil {
+		p, err := oidc.NewProvider(ctx, cfg.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("OIDC-Provider %s nicht erreichbar: %w", cfg.Issuer, err)
+		}
+		oidcProvider = p
+	}
+	return oidcProvider, &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     oidcProvider.Endpoint(),
+		Scopes:       cfg.Scopes,
+	}, nil
+}
+
+// OIDCAuthURL beginnt eine Anmeldung und liefert die Adresse beim Identity
+// Provider sowie den state, der im Browser als Cookie gehalten wird
+func OIDCAuthURL(next string) (string, string, error) {
+	ctx, cancel := oidcContext()
+	defer cancel()
+	_, conf, err := oidcSetup(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	oidcMu.Lock()
+	for s, l := range oidcPending {
+		if now.After(l.expires) {
+			delete(oidcPending, s)
+		}
+	}
+	oidcPending[state] = oidcLogin{nonce: nonce, verifier: verifier, next: next, expires: now.Add(OIDCLoginTimeout)}
+	oidcMu.Unlock()
+
+	return conf.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), state, nil
+}
+
+// OIDCCallback schliesst eine Anmeldung ab. state und code stammen aus dem
+// Rücksprung, cookieState aus dem Cookie des Browsers, der die Anmeldung
+// begonnen hat. Geliefert werden der Benutzer und das Ziel nach der Anmeldung,
+// ohne passende Rolle ist der Benutzer nil.
+func OIDCCallback(database *sql.DB, state, cookieState, code string) (*Account, string, error) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return nil, "", fmt.Errorf("der state der OIDC-Anmeldung passt nicht zu diesem Browser")
+	}
+	oidcMu.Lock()
+	login, ok := oidcPending[state]
+	delete(oidcPending, state)
+	oidcMu.Unlock()
+	if !ok || time.Now().After(login.expires) {
+		return nil, "", fmt.Errorf("die OIDC-Anmeldung ist unbekannt oder abgelaufen")
+	}
+
+	ctx, cancel := oidcContext()
+	defer cancel()
+	provider, conf, err := oidcSetup(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := conf.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("OIDC-Code konnte nicht eingelöst werden: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("der OIDC-Provider hat kein id_token geliefert")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: conf.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("ungültiges id_token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(login.nonce)) != 1 {
+		return nil, "", fmt.Errorf("die nonce im id_token passt nicht zur Anmeldung")
+	}
+
+	account, err := oidcAccount(database, idToken)
+	return account, login.next, err
+}
+
+// oidcAccount bestimmt Benutzername und Rolle aus den Claims und legt den
+// Benutzer beim ersten Mal an
+func oidcAccount(database *sql.DB, idToken *oidc.IDToken) (*Account, error) {
+	cfg := app.Config.OIDC
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	name, _ := claims[cfg.UsernameClaim].(string)
+	if err := checkUsername(name); err != nil {
+		return nil, fmt.Errorf("Claim %s von %s: %w", cfg.UsernameClaim, idToken.Subject, err)
+	}
+	// ein gleichnamiger lokaler oder LDAP-Benutzer darf nicht übernommen werden
+	existing, err := LookupAccount(database, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.Source != SourceOIDC {
+		app.LogIt.Warn(fmt.Sprintf("OIDC-Anmeldung von %s abgelehnt, der Benutzer stammt aus %s", name, existingSource(existing)))
+		return nil, nil
+	}
+
+	var groups []string
+	switch v := claims[cfg.RoleClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []any:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	role, pools := groupRole(cfg.GroupRoles, groups)
+	if role == "" {
+		app.LogIt.Info(fmt.Sprintf("OIDC-Benutzer %s hat im Claim %s keine Gruppe mit einer Rolle", name, cfg.RoleClaim))
+		return nil, nil
+	}
+
+	now := time.Now().Format(timestampLayout)
+	err = db.SaveExternalUser(database, &db.User{
+		Username:  name,
+		Role:      role,
+		Pools:     pools,
+		Source:    SourceOIDC,
+		LastLogin: now,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		app.LogIt.Info(fmt.Sprintf("OIDC-Benutzer %s als %s angelegt", name, role))
+	}
+	return &Account{Name: name, Role: role, Pools: pools, Source: SourceOIDC}, nil
+}
+
+func existingSource(a *Account) string {
+	if a.Config {
+		return "der Konfiguration"
+	}
+	return a.Source
+}
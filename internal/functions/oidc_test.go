@@ -0,0 +1,309 @@
+package functions
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// oidcIdP ist ein Identity Provider mit Discovery, JWKS und Token-Endpunkt.
+// Ein Code wird mit register vergeben und liefert ein id_token mit den
+// angegebenen Claims.
+type oidcIdP struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]oidcCode
+}
+
+type oidcCode struct {
+	claims    map[string]any
+	challenge string
+}
+
+func newOIDCIdP(t *testing.T) *oidcIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &oidcIdP{key: key, codes: map[string]oidcCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/auth",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		code, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "zugang",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idp.sign(t, code.claims),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// sign liefert ein mit RS256 signiertes JWT
+func (idp *oidcIdP) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Error(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// register vergibt für die Anmeldung hinter authURL einen Code, der ein
+// id_token für name mit groups liefert. nonce ersetzt die nonce der Anmeldung.
+func (idp *oidcIdP) register(t *testing.T, authURL, name string, groups any, nonce string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if nonce == "" {
+		nonce = q.Get("nonce")
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "blv" {
+		t.Fatalf("unerwartete Anmeldeadresse %s", authURL)
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"iss":                idp.URL,
+		"aud":                "blv",
+		"sub":                "sub-" + name,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              nonce,
+		"preferred_username": name,
+	}
+	if groups != nil {
+		claims["groups"] = groups
+	}
+	code := "code-" + q.Get("state")
+	idp.mu.Lock()
+	idp.codes[code] = oidcCode{claims: claims, challenge: q.Get("code_challenge")}
+	idp.mu.Unlock()
+	return code
+}
+
+// oidcTest richtet Datenbank, Identity Provider und Konfiguration ein
+func oidcTest(t *testing.T) (*sql.DB, *oidcIdP) {
+	t.Helper()
+	database := testDB(t)
+	idp := newOIDCIdP(t)
+	app.Config.OIDC = app.OIDCConfig{
+		Enabled:       true,
+		Issuer:        idp.URL,
+		ClientID:      "blv",
+		ClientSecret:  "geheim",
+		RedirectURL:   "https://blv.example.org/oidc/callback",
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		GroupRoles: []app.GroupRole{
+			{Group: "blv-admins", Role: RoleAdmin},
+			{Group: "blv-netz", Role: RoleEditor, Pools: []string{"netz"}},
+		},
+		Timeout: 5 * time.Second,
+	}
+	reset := func() {
+		oidcMu.Lock()
+		oidcProvider = nil
+		clear(oidcPending)
+		oidcMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+	return database, idp
+}
+
+func oidcStart(t *testing.T, next string) (string, string) {
+	t.Helper()
+	authURL, state, err := OIDCAuthURL(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authURL, state
+}
+
+func TestOIDCCallbackRoles(t *testing.T) {
+	database, idp := oidcTest(t)
+
+	// Rolle aus einem einzelnen Wert im Claim
+	authURL, state := oidcStart(t, "/pools/netz")
+	account, next, err := OIDCCallback(database, state, state, idp.register(t, authURL, "lisa", "blv-netz", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Role != RoleEditor || strings.Join(account.Pools, ",") != "netz" || account.Source != SourceOIDC || next != "/pools/netz" {
+		t.Fatalf("Anmeldung lisa = %+v, %q", account, next)
+	}
+	if u, err := db.GetUser(database, "lisa"); err != nil || u == nil || u.Source != SourceOIDC || u.Role != RoleEditor {
+		t.Fatalf("lisa in der Datenbank = %+v, %v", u, err)
+	}
+
+	// Rolle aus einer Liste, ein bestehender OIDC-Benutzer wird aktualisiert
+	authURL, state = oidcStart(t, "")
+	account, _, err = OIDCCallback(database, state, state, idp.register(t, authURL, "lisa", []any{"andere", "blv-admins", 7}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.Role != RoleAdmin || len(account.Pools) != 0 {
+		t.Fatalf("zweite Anmeldung lisa = %+v", account)
+	}
+	if u, _ := db.GetUser(database, "lisa"); u == nil || u.Role != RoleAdmin {
+		t.Errorf("lisa in der Datenbank = %+v, erwartet admin", u)
+	}
+
+	// ohne Gruppe mit Rolle keine Anmeldung
+	for _, groups := range []any{nil, "andere", []any{"andere"}, map[string]any{"blv-admins": true}} {
+		authURL, state = oidcStart(t, "")
+		account, _, err = OIDCCallback(database, state, state, idp.register(t, authURL, "otto", groups, ""))
+		if err != nil || account != nil {
+			t.Errorf("Anmeldung mit Gruppen %v = %+v, %v, erwartet keine Anmeldung", groups, account, err)
+		}
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	database, idp := oidcTest(t)
+
+	authURL, state := oidcStart(t, "")
+	code := idp.register(t, authURL, "lisa", "blv-netz", "")
+	for _, tt := range []struct{ state, cookie string }{
+		{"", ""},
+		{state, ""},
+		{state, "anderer-browser"},
+		{"fremd", state},
+		{"unbekannt", "unbekannt"},
+	} {
+		if account, _, err := OIDCCallback(database, tt.state, tt.cookie, code); err == nil || account != nil {
+			t.Errorf("state %q mit Cookie %q: %+v, %v, erwartet Fehler", tt.state, tt.cookie, account, err)
+		}
+	}
+	// die Anmeldung gilt weiter für den Browser, der sie begonnen hat, aber nur einmal
+	if account, _, err := OIDCCallback(database, state, state, code); err != nil || account == nil {
+		t.Fatalf("Anmeldung nach fremden Rücksprüngen = %+v, %v", account, err)
+	}
+	if _, _, err := OIDCCallback(database, state, state, code); err == nil {
+		t.Error("state zweimal verwendet")
+	}
+}
+
+func TestOIDCCallbackExpired(t *testing.T) {
+	database, idp := oidcTest(t)
+
+	authURL, state := oidcStart(t, "")
+	code := idp.register(t, authURL, "lisa", "blv-netz", "")
+	oidcMu.Lock()
+	login := oidcPending[state]
+	login.expires = time.Now().Add(-time.Second)
+	oidcPending[state] = login
+	oidcMu.Unlock()
+	if _, _, err := OIDCCallback(database, state, state, code); err == nil || !strings.Contains(err.Error(), "abgelaufen") {
+		t.Errorf("abgelaufene Anmeldung: %v", err)
+	}
+
+	// abgelaufene Anmeldungen werden beim nächsten Beginn aufgeräumt
+	oidcMu.Lock()
+	oidcPending["alt"] = oidcLogin{expires: time.Now().Add(-time.Second)}
+	oidcMu.Unlock()
+	oidcStart(t, "")
+	oidcMu.Lock()
+	_, ok := oidcPending["alt"]
+	oidcMu.Unlock()
+	if ok {
+		t.Error("abgelaufene Anmeldung nicht aufgeräumt")
+	}
+}
+
+func TestOIDCCallbackNonce(t *testing.T) {
+	database, idp := oidcTest(t)
+
+	authURL, state := oidcStart(t, "")
+	code := idp.register(t, authURL, "lisa", "blv-netz", "fremde-nonce")
+	if account, _, err := OIDCCallback(database, state, state, code); err == nil || account != nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("falsche nonce: %+v, %v", account, err)
+	}
+	if u, _ := db.GetUser(database, "lisa"); u != nil {
+		t.Errorf("Benutzer trotz falscher nonce angelegt: %+v", u)
+	}
+}
+
+func TestOIDCCallbackExistingUser(t *testing.T) {
+	database, idp := oidcTest(t)
+	if err := AddUser(database, "alice", "lokalpasswort", RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveExternalUser(database, &db.User{Username: "lisa", Role: RoleViewer, Source: SourceLDAP, CreatedAt: time.Now().Format(timestampLayout)}); err != nil {
+		t.Fatal(err)
+	}
+	app.Config.Users = []app.UserConfig{{Name: "notfall", Role: RoleAdmin}}
+
+	for _, name := range []string{"alice", "lisa", "notfall"} {
+		authURL, state := oidcStart(t, "")
+		account, _, err := OIDCCallback(database, state, state, idp.register(t, authURL, name, "blv-admins", ""))
+		if err != nil || account != nil {
+			t.Errorf("OIDC-Anmeldung als bestehender Benutzer %s = %+v, %v", name, account, err)
+		}
+	}
+	for name, source := range map[string]string{"alice": SourceLocal, "lisa": SourceLDAP} {
+		if u, err := db.GetUser(database, name); err != nil || u == nil || u.Source != source || u.Role != RoleViewer {
+			t.Errorf("%s nach der OIDC-Anmeldung = %+v, %v", name, u, err)
+		}
+	}
+	if u, _ := db.GetUser(database, "notfall"); u != nil {
+		t.Errorf("Benutzer aus der Konfiguration in der Datenbank angelegt: %+v", u)
+	}
+}
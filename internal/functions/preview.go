@@ -0,0 +1,108 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// FileDiff beschreibt, was eine Aktivierung an einer Datei im ListPath ändert
+type FileDiff struct {
+	File   string
+	Status string // neu, geändert, unverändert, verwaist
+	Diff   []string
+}
+
+// PreviewActivation schreibt die Listen in ein temporäres Verzeichnis und
+// vergleicht sie mit den aktuellen Dateien im ListPath, ohne dort etwas zu
+// ändern. Verwaist sind Listen im ListPath, die nicht mehr geschrieben und
+// beim Aktivieren entfernt werden.
+func PreviewActivation(database *sql.DB) ([]FileDiff, error) {
+	preview, err := os.MkdirTemp("", "blv-preview-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(preview)
+	preview += "/"
+
+	if err := StageDB(database, preview); err != nil {
+		return nil, err
+	}
+	files, err := ValidateStaging(preview)
+	if err != nil {
+		return nil, err
+	}
+	return diffStaging(preview, files)
+}
+
+// ErrPreviewOutdated meldet, dass eine Aktivierung nicht mehr der bestätigten
+// Vorschau entspricht
+var ErrPreviewOutdated = errors.New("Datenbank oder Listen haben sich seit der Vorschau geändert")
+
+// PreviewFingerprint fasst eine Vorschau zusammen. Beim Bestätigen wird er
+// mit der Aktivierung verglichen, damit nur aktiviert wird, was angezeigt wurde.
+func PreviewFingerprint(diffs []FileDiff) string {
+	h := sha256.New()
+	for _, d := range diffs {
+		h.Write([]byte(d.File + "\x00" + d.Status + "\x00"))
+		for _, line := range d.Diff {
+			h.Write([]byte(line + "\n"))
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// diffStaging vergleicht die Dateien files im Verzeichnis staging mit den
+// aktuellen Dateien im ListPath
+func diffStaging(staging string, files []string) ([]FileDiff, error) {
+	var diffs []FileDiff
+	for _, rel := range files {
+		newText, err := os.ReadFile(filepath.Join(staging, rel))
+		if err != nil {
+			return nil, err
+		}
+		current := filepath.Join(app.Config.ListPath, rel)
+		if !helpers.FileExists(current) {
+			diffs = append(diffs, FileDiff{
+				File:   rel,
+				Status: "neu",
+				Diff:   helpers.UnifiedDiff("/dev/null", rel, "", string(newText)),
+			})
+			continue
+		}
+		oldText, err := os.ReadFile(current)
+		if err != nil {
+			return nil, err
+		}
+		diff := helpers.UnifiedDiff(rel, rel, string(oldText), string(newText))
+		status := "geändert"
+		if diff == nil {
+			status = "unverändert"
+		}
+		diffs = append(diffs, FileDiff{File: rel, Status: status, Diff: diff})
+	}
+
+	orphans, err := orphanedListFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range orphans {
+		oldText, err := os.ReadFile(filepath.Join(app.Config.ListPath, rel))
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, FileDiff{
+			File:   rel,
+			Status: "verwaist",
+			Diff:   helpers.UnifiedDiff(rel, "/dev/null", string(oldText), ""),
+		})
+	}
+	return diffs, nil
+}
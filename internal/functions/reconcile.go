@@ -0,0 +1,244 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// ReconcileReport beschreibt die Unterschiede zwischen den Listen im ListPath
+// und der Datenbank, je Pool. Pools mit nicht verstandenen Zeilen stehen unter
+// Skipped und bleiben beim Abgleich unberührt.
+type ReconcileReport struct {
+	Pools    []PoolReconcile
+	Added    int
+	Removed  int
+	Changed  int
+	Unparsed []string
+	Skipped  []string
+	Applied  bool
+}
+
+// ErrReconcileOutdated meldet, dass sich Datenbank oder Listen seit dem
+// angezeigten Bericht geändert haben
+var ErrReconcileOutdated = errors.New("Datenbank oder Listen haben sich seit dem Bericht geändert")
+
+type PoolReconcile struct {
+	Pool    string
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+type entryKey struct {
+	pool       string
+	start, end uint32
+}
+
+// ReconcileDB gleicht die Datenbank mit den Listen im ListPath ab: Einträge,
+// die nur in den Listen stehen, werden angelegt, Einträge, die nur in der
+// Datenbank stehen, gelöscht und abweichende Status und Kommentare übernommen.
+// Einträge ohne Status werden nie exportiert und bleiben daher unberührt,
+// ebenso Pools, deren Listen nicht verstandene Zeilen enthalten.
+// Mit apply=false wird nur der Bericht erstellt, sonst wird die Datenbank
+// vorher gesichert.
+func ReconcileDB(database *sql.DB, apply bool) (*ReconcileReport, error) {
+	return reconcile(database, apply, "")
+}
+
+// ReconcileReviewed übernimmt den Abgleich nur, wenn er noch dem Bericht mit
+// fingerprint entspricht, sonst wird ErrReconcileOutdated mit dem neuen
+// Bericht geliefert
+func ReconcileReviewed(database *sql.DB, fingerprint string) (*ReconcileReport, error) {
+	if fingerprint == "" {
+		report, err := reconcile(database, false, "")
+		if err != nil {
+			return nil, err
+		}
+		return report, ErrReconcileOutdated
+	}
+	return reconcile(database, true, fingerprint)
+}
+
+// ReconcileFingerprint fasst die Änderungen eines Berichts zusammen
+func ReconcileFingerprint(report *ReconcileReport) string {
+	h := sha256.New()
+	for _, p := range report.Pools {
+		fmt.Fprintf(h, "%s\x00%q\x00%q\x00%q\x00", p.Pool, p.Added, p.Removed, p.Changed)
+	}
+	fmt.Fprintf(h, "%q", report.Skipped)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func reconcile(database *sql.DB, apply bool, fingerprint string) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+	var desired []db.PoolEntry
+	skipped := map[string]bool{}
+	for _, dir := range []struct{ name, status string }{{"blocklists", "b"}, {"whitelists", "w"}} {
+		entries, err := os.ReadDir(app.Config.ListPath + dir.name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != ".conf" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(app.Config.ListPath, dir.name, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			poolName := strings.TrimSuffix(entry.Name(), ".conf")
+			unparsed := len(report.Unparsed)
+			parsed, err := confToEntries(data, poolName, dir.status, &report.Unparsed)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", dir.name, entry.Name(), err)
+			}
+			if len(report.Unparsed) > unparsed {
+				// sonst würden die nicht verstandenen Einträge gelöscht
+				skipped[poolName] = true
+			}
+			desired = append(desired, parsed...)
+		}
+	}
+
+	all, err := db.ListEntries(database)
+	if err != nil {
+		return nil, err
+	}
+	current := slices.DeleteFunc(all, func(e db.PoolEntry) bool {
+		return (e.Status != "w" && e.Status != "b") || skipped[e.Name]
+	})
+	desired = slices.DeleteFunc(desired, func(e db.PoolEntry) bool { return skipped[e.Name] })
+	for name := range skipped {
+		report.Skipped = append(report.Skipped, name)
+	}
+	slices.Sort(report.Skipped)
+
+	inserts, deletes, updates := reconcileEntries(current, desired, report)
+	if !apply {
+		return report, nil
+	}
+	if fingerprint != "" && ReconcileFingerprint(report) != fingerprint {
+		return report, ErrReconcileOutdated
+	}
+	if _, err := BackupDB(database); err != nil {
+		return nil, fmt.Errorf("Datenbank konnte nicht gesichert werden: %w", err)
+	}
+	if err := db.ApplyChanges(database, inserts, deletes, updates); err != nil {
+		return nil, err
+	}
+	report.Applied = true
+	app.LogIt.Info(fmt.Sprintf("Datenbank mit den Listen abgeglichen: %d hinzugefügt, %d entfernt, %d geändert", report.Added, report.Removed, report.Changed))
+	if len(report.Skipped) > 0 {
+		app.LogIt.Warn(fmt.Sprintf("Pools %s wegen nicht verstandener Zeilen nicht abgeglichen", strings.Join(report.Skipped, ", ")))
+	}
+	return report, nil
+}
+
+// confToEntries liest eine Liste und liefert ihre Einträge für poolName
+func confToEntries(data []byte, poolName, status string, unparsed *[]string) ([]db.PoolEntry, error) {
+	parsed, notParsed, err := ParseConf(bytes.NewReader(data), status)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range notParsed {
+		*unparsed = append(*unparsed, poolName+": "+u)
+	}
+	var entries []db.PoolEntry
+	for _, p := range parsed {
+		start, end, err := helpers.GetIPRange(p.CIDR)
+		if err != nil {
+			*unparsed = append(*unparsed, poolName+": "+p.CIDR)
+			continue
+		}
+		entries = append(entries, db.PoolEntry{
+			StartIPInt: start,
+			EndIPInt:   end,
+			CIDR:       p.CIDR,
+			Name:       poolName,
+			Comment:    p.Comment,
+			Status:     p.Status,
+		})
+	}
+	return entries, nil
+}
+
+// reconcileEntries vergleicht current mit desired, ergänzt den Bericht und
+// liefert die nötigen Änderungen
+func reconcileEntries(current, desired []db.PoolEntry, report *ReconcileReport) (inserts, deletes, updates []db.PoolEntry) {
+	pools := map[string]*PoolReconcile{}
+	poolReport := func(name string) *PoolReconcile {
+		if pools[name] == nil {
+			pools[name] = &PoolReconcile{Pool: name}
+		}
+		return pools[name]
+	}
+
+	want := map[entryKey]db.PoolEntry{}
+	for _, e := range desired {
+		// taucht ein CIDR in beiden Listen eines Pools auf, gilt die Whitelist
+		want[entryKey{e.Name, e.StartIPInt, e.EndIPInt}] = e
+	}
+
+	seen := map[entryKey]bool{}
+	for _, e := range current {
+		key := entryKey{e.Name, e.StartIPInt, e.EndIPInt}
+		w, ok := want[key]
+		if !ok || seen[key] {
+			// nicht mehr in den Listen oder doppelt in der Datenbank
+			deletes = append(deletes, e)
+			poolReport(e.Name).Removed = append(poolReport(e.Name).Removed, e.CIDR)
+			continue
+		}
+		seen[key] = true
+		if w.Status != e.Status || w.Comment != e.Comment {
+			var changes []string
+			if w.Status != e.Status {
+				changes = append(changes, fmt.Sprintf("Status %s -> %s", e.Status, w.Status))
+			}
+			if w.Comment != e.Comment {
+				changes = append(changes, fmt.Sprintf("Kommentar %q -> %q", e.Comment, w.Comment))
+			}
+			e.Status, e.Comment = w.Status, w.Comment
+			updates = append(updates, e)
+			poolReport(e.Name).Changed = append(poolReport(e.Name).Changed, e.CIDR+": "+strings.Join(changes, ", "))
+		}
+	}
+	for _, e := range desired {
+		key := entryKey{e.Name, e.StartIPInt, e.EndIPInt}
+		if seen[key] || want[key] != e {
+			continue
+		}
+		seen[key] = true
+		inserts = append(inserts, e)
+		poolReport(e.Name).Added = append(poolReport(e.Name).Added, e.CIDR)
+	}
+
+	report.Added += len(inserts)
+	report.Removed += len(deletes)
+	report.Changed += len(updates)
+	var names []string
+	for name := range pools {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		report.Pools = append(report.Pools, *pools[name])
+	}
+
+	return inserts, deletes, updates
+}
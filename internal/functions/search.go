@@ -0,0 +1,96 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+const (
+	searchPageSize    = 50
+	maxSearchPageSize = 500
+)
+
+// SearchMatches sind die Vergleiche einer gesuchten Adresse mit den Einträgen
+var SearchMatches = []string{db.MatchContains, db.MatchWithin, db.MatchOverlaps}
+
+// SearchSorts sind die Spalten, nach denen sortiert werden kann
+var SearchSorts = []string{"ip", "pool", "status", "comment", "size"}
+
+// SearchQuery ist eine Suche über alle Pools, wie sie aus dem Formular oder
+// der API kommt. IP ist eine Adresse, ein CIDR oder ein Bereich, Pool ein
+// Muster mit * und ?.
+type SearchQuery struct {
+	IP      string
+	Match   string
+	Comment string
+	Pool    string
+	Status  string
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+	Pools   []string // nur in diesen Pools suchen
+}
+
+// SearchResult ist eine Seite der Treffer
+type SearchResult struct {
+	Entries []db.PoolEntry
+	Total   int
+	Page    int
+	Pages   int
+	PerPage int
+}
+
+// Search prüft die Angaben einer Suche und liefert die verlangte Seite
+func Search(database *sql.DB, q SearchQuery) (*SearchResult, error) {
+	f := db.SearchFilter{
+		Match:   q.Match,
+		Comment: strings.TrimSpace(q.Comment),
+		Pool:    strings.TrimSpace(q.Pool),
+		Pools:   q.Pools,
+		Status:  q.Status,
+		Sort:    q.Sort,
+		Desc:    q.Desc,
+	}
+	if ip := strings.TrimSpace(q.IP); ip != "" {
+		start, end, err := helpers.ParseIPSpec(ip)
+		if err != nil {
+			return nil, fmt.Errorf("ungültige Adresse %q: %w", ip, err)
+		}
+		f.HasRange, f.Start, f.End = true, start, end
+	}
+	if f.Match == "" {
+		f.Match = db.MatchContains
+	}
+	if !helpers.StringInSlice(f.Match, SearchMatches) {
+		return nil, fmt.Errorf("unbekannter Vergleich %q (%s)", f.Match, strings.Join(SearchMatches, ", "))
+	}
+	if f.Status != "" && f.Status != "w" && f.Status != "b" {
+		return nil, fmt.Errorf("status muss w oder b sein")
+	}
+	if f.Sort == "" {
+		f.Sort = "ip"
+	}
+	if !helpers.StringInSlice(f.Sort, SearchSorts) {
+		return nil, fmt.Errorf("unbekannte Sortierung %q (%s)", f.Sort, strings.Join(SearchSorts, ", "))
+	}
+
+	res := &SearchResult{Page: max(q.Page, 1), PerPage: q.PerPage}
+	if res.PerPage <= 0 {
+		res.PerPage = searchPageSize
+	}
+	res.PerPage = min(res.PerPage, maxSearchPageSize)
+	f.Limit, f.Offset = res.PerPage, (res.Page-1)*res.PerPage
+
+	entries, total, err := db.SearchEntries(database, f)
+	if err != nil {
+		return nil, err
+	}
+	res.Entries, res.Total = entries, total
+	res.Pages = (total + res.PerPage - 1) / res.PerPage
+	return res, nil
+}
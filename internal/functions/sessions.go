@@ -0,0 +1,103 @@
+package functions
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// last_seen wird höchstens so oft geschrieben
+const sessionTouchPeriod = time.Minute
+
+// SessionInfo ist eine gültige Anmeldung mit dem Benutzer und dem CSRF-Token
+// für Formulare
+type SessionInfo struct {
+	Account *Account
+	CSRF    string
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// CreateSession meldet einen Benutzer an und liefert die Session-ID für das
+// Cookie. Abgelaufene Sessions werden dabei aufgeräumt.
+func CreateSession(database *sql.DB, username string) (string, error) {
+	now := time.Now()
+	if err := db.DeleteExpiredSessions(database,
+		now.Add(-app.Config.Session.IdleTimeout).Format(timestampLayout),
+		now.Add(-app.Config.Session.MaxAge).Format(timestampLayout)); err != nil {
+		return "", err
+	}
+	id, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	err = db.CreateSession(database, &db.Session{
+		Hash:      hashToken(id),
+		Username:  username,
+		CSRF:      csrf,
+		CreatedAt: now.Format(timestampLayout),
+		LastSeen:  now.Format(timestampLayout),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Session für %s konnte nicht angelegt werden: %w", username, err)
+	}
+	app.LogIt.Info(fmt.Sprintf("Benutzer %s angemeldet", username))
+	return id, nil
+}
+
+// ValidateSession liefert die Anmeldung zu einer Session-ID. Unbekannte,
+// abgelaufene und Sessions gelöschter Benutzer ergeben nil.
+func ValidateSession(database *sql.DB, id string) (*SessionInfo, error) {
+	hash := hashToken(id)
+	s, err := db.GetSession(database, hash)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	now := time.Now()
+	created, err1 := time.ParseInLocation(timestampLayout, s.CreatedAt, time.Local)
+	lastSeen, err2 := time.ParseInLocation(timestampLayout, s.LastSeen, time.Local)
+	if err1 != nil || err2 != nil ||
+		now.After(lastSeen.Add(app.Config.Session.IdleTimeout)) ||
+		now.After(created.Add(app.Config.Session.MaxAge)) {
+		return nil, db.DeleteSession(database, hash)
+	}
+	account, err := LookupAccount(database, s.Username)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, db.DeleteSession(database, hash)
+	}
+	if now.Sub(lastSeen) >= sessionTouchPeriod {
+		if err := db.TouchSession(database, hash, now.Format(timestampLayout)); err != nil {
+			return nil, err
+		}
+	}
+	return &SessionInfo{Account: account, CSRF: s.CSRF}, nil
+}
+
+// EndSession meldet eine Session ab
+func EndSession(database *sql.DB, id string) error {
+	return db.DeleteSession(database, hashToken(id))
+}
+
+// CheckCSRF vergleicht das Token aus einem Formular mit dem der Session
+func (s *SessionInfo) CheckCSRF(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) == 1
+}
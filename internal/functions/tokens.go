@@ -0,0 +1,94 @@
+package functions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+// TokenScopes sind die Berechtigungen, die ein API-Token haben kann
+var TokenScopes = []string{"read", "check", "write", "activate"}
+
+const (
+	tokenPrefix       = "blv_"
+	tokenExpiryLayout = "2006-01-02"
+)
+
+func hashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken legt ein Token an und liefert es im Klartext. Es wird nur
+// einmal angezeigt, gespeichert wird der Hash.
+func CreateToken(database *sql.DB, name string, scopes, pools []string, expiresAt string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("das Token braucht einen Namen")
+	}
+	if len(scopes) == 0 {
+		return "", fmt.Errorf("das Token braucht mindestens einen Scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(TokenScopes, scope) {
+			return "", fmt.Errorf("unbekannter Scope %q", scope)
+		}
+	}
+	if expiresAt != "" {
+		if _, err := time.Parse(tokenExpiryLayout, expiresAt); err != nil {
+			return "", fmt.Errorf("ungültiges Ablaufdatum %q (JJJJ-MM-TT)", expiresAt)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	plain := tokenPrefix + hex.EncodeToString(secret)
+	err := db.CreateAPIToken(database, &db.APIToken{
+		Name:      name,
+		Hash:      hashToken(plain),
+		Scopes:    scopes,
+		Pools:     pools,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().Format(timestampLayout),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Token %s konnte nicht angelegt werden: %w", name, err)
+	}
+	app.LogIt.Info(fmt.Sprintf("API-Token %s angelegt (Scopes %s)", name, strings.Join(scopes, ",")))
+	return plain, nil
+}
+
+// AuthenticateToken prüft ein Token im Klartext und hält die Verwendung fest.
+// Für unbekannte oder abgelaufene Tokens wird nil geliefert.
+func AuthenticateToken(database *sql.DB, plain string) (*db.APIToken, error) {
+	if !strings.HasPrefix(plain, tokenPrefix) {
+		return nil, nil
+	}
+	token, err := db.FindAPITokenByHash(database, hashToken(plain))
+	if err != nil || token == nil {
+		return nil, err
+	}
+	if token.ExpiresAt != "" {
+		expires, err := time.ParseInLocation(tokenExpiryLayout, token.ExpiresAt, time.Local)
+		// das Token gilt bis und mit dem Ablaufdatum
+		if err != nil || !time.Now().Before(expires.AddDate(0, 0, 1)) {
+			return nil, nil
+		}
+	}
+	now := time.Now().Format(timestampLayout)
+	if err := db.TouchAPIToken(database, token.ID, now); err != nil {
+		return nil, err
+	}
+	token.LastUsed = now
+	return token, nil
+}
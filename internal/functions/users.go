@@ -0,0 +1,356 @@
+package functions
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+)
+
+const (
+	minPasswordLength = 10
+	// BasicAuth schickt das Passwort mit jeder Anfrage, bcrypt wird deshalb
+	// nur alle authCacheTTL erneut geprüft
+	authCacheTTL = 5 * time.Minute
+)
+
+// Rollen mit aufsteigenden Berechtigungen: viewer sieht Pools und prüft IPs,
+// editor pflegt Einträge, admin aktiviert, setzt zurück, lädt hoch und löscht
+// Pools
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Herkunft eines Benutzers in der Datenbank
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+	SourceOIDC  = "oidc"
+)
+
+// Account ist ein angemeldeter Benutzer. Mit Pools gilt seine Rolle nur für
+// diese Pools, in allen anderen darf er nur lesen. Aktionen, die alle Pools
+// betreffen, sind Benutzern ohne Poolbeschränkung vorbehalten.
+type Account struct {
+	Name   string
+	Role   string
+	Pools  []string
+	Config bool   // in der Konfiguration definiert
+	Source string // local, ldap oder oidc für Benutzer aus der Datenbank
+}
+
+// HasRole meldet, ob die Rolle des Benutzers mindestens role ist
+func (a *Account) HasRole(role string) bool {
+	return a != nil && slices.Index(Roles, a.Role) >= slices.Index(Roles, role)
+}
+
+// Can meldet, ob der Benutzer mit role im Pool handeln darf; ohne Pool geht
+// es um eine Aktion, die alle Pools betrifft
+func (a *Account) Can(role, pool string) bool {
+	if !a.HasRole(role) {
+		return false
+	}
+	if role == RoleViewer || len(a.Pools) == 0 {
+		return true
+	}
+	return pool != "" && slices.Contains(a.Pools, pool)
+}
+
+func (a *Account) CanEdit(pool string) bool {
+	return a.Can(RoleEditor, pool)
+}
+
+func (a *Account) IsAdmin() bool {
+	return a.Can(RoleAdmin, "")
+}
+
+type authCacheEntry struct {
+	sum     [32]byte
+	until   time.Time
+	account *Account
+}
+
+var (
+	authCacheMu sync.Mutex
+	authCache   = map[string]authCacheEntry{}
+	// wird für unbekannte Benutzer verglichen, damit die Antwortzeit nicht
+	// verrät, ob es den Benutzer gibt
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("blv"), bcrypt.DefaultCost)
+)
+
+// HashPassword liefert den bcrypt-Hash eines Passworts, wie er in der
+// Datenbank und unter users in der Konfiguration steht
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("das Passwort muss mindestens %d Zeichen lang sein", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func configUser(name string) (app.UserConfig, bool) {
+	for _, u := range app.Config.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return app.UserConfig{}, false
+}
+
+func checkUsername(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("der Benutzer braucht einen Namen")
+	case strings.ContainsAny(name, ": \t"):
+		return fmt.Errorf("der Benutzername %q darf weder Doppelpunkt noch Leerzeichen enthalten", name)
+	case strings.HasPrefix(name, "token:"):
+		return fmt.Errorf("der Benutzername %q ist für API-Tokens reserviert", name)
+	}
+	return nil
+}
+
+func checkRole(role string) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("unbekannte Rolle %q (%s)", role, strings.Join(Roles, ", "))
+	}
+	return nil
+}
+
+// AddUser legt einen Benutzer mit Rolle und optional auf Pools beschränkt in
+// der Datenbank an
+func AddUser(database *sql.DB, name, password, role string, pools []string) error {
+	if err := checkUsername(name); err != nil {
+		return err
+	}
+	if err := checkRole(role); err != nil {
+		return err
+	}
+	if _, ok := configUser(name); ok {
+		return fmt.Errorf("der Benutzer %s ist bereits in der Konfiguration definiert", name)
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = db.CreateUser(database, &db.User{
+		Username:     name,
+		PasswordHash: hash,
+		Role:         role,
+		Pools:        pools,
+		Source:       SourceLocal,
+		CreatedAt:    time.Now().Format(timestampLayout),
+	})
+	if err != nil {
+		return fmt.Errorf("Benutzer %s konnte nicht angelegt werden: %w", name, err)
+	}
+	app.LogIt.Info(fmt.Sprintf("Benutzer %s als %s angelegt", name, role))
+	return nil
+}
+
+// SetPassword setzt das Passwort eines Benutzers in der Datenbank neu.
+// Benutzer aus der Konfiguration werden dort gepflegt.
+func SetPassword(database *sql.DB, name, password string) error {
+	if _, ok := configUser(name); ok {
+		return fmt.Errorf("der Benutzer %s ist in der Konfiguration definiert, das Passwort wird dort geändert", name)
+	}
+	if err := checkLocalUser(database, name, "das Passwort"); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := db.SetUserPassword(database, name, hash); err != nil {
+		return err
+	}
+	forgetAuth(name)
+	if err := db.DeleteUserSessions(database, name); err != nil {
+		return err
+	}
+	app.LogIt.Info(fmt.Sprintf("Passwort von Benutzer %s neu gesetzt", name))
+	return nil
+}
+
+// SetRole ändert Rolle und Pools eines Benutzers in der Datenbank. Der letzte
+// Administrator ohne Poolbeschränkung kann nicht herabgestuft werden.
+func SetRole(database *sql.DB, name, role string, pools []string) error {
+	if _, ok := configUser(name); ok {
+		return fmt.Errorf("der Benutzer %s ist in der Konfiguration definiert, die Rolle wird dort geändert", name)
+	}
+	if err := checkRole(role); err != nil {
+		return err
+	}
+	if err := checkLocalUser(database, name, "die Rolle"); err != nil {
+		return err
+	}
+	next := &Account{Name: name, Role: role, Pools: pools}
+	if !next.IsAdmin() {
+		if err := keepAdmin(database, name); err != nil {
+			return err
+		}
+	}
+	if _, err := db.SetUserRole(database, name, role, pools); err != nil {
+		return err
+	}
+	forgetAuth(name)
+	app.LogIt.Info(fmt.Sprintf("Benutzer %s ist nun %s (Pools: %s)", name, role, strings.Join(pools, ",")))
+	return nil
+}
+
+// checkLocalUser stellt sicher, dass es den Benutzer gibt und what bei ihm
+// in der Datenbank gepflegt wird, nicht in einem Verzeichnis
+func checkLocalUser(database *sql.DB, name, what string) error {
+	u, err := db.GetUser(database, name)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return fmt.Errorf("den Benutzer %s gibt es nicht", name)
+	}
+	if u.Source != SourceLocal {
+		return fmt.Errorf("der Benutzer %s stammt aus %s, %s wird dort gepflegt", name, u.Source, what)
+	}
+	return nil
+}
+
+// DeleteUser löscht einen Benutzer aus der Datenbank
+func DeleteUser(database *sql.DB, name string) error {
+	if _, ok := configUser(name); ok {
+		return fmt.Errorf("der Benutzer %s ist in der Konfiguration definiert und wird dort entfernt", name)
+	}
+	if err := keepAdmin(database, name); err != nil {
+		return err
+	}
+	if err := db.DeleteUser(database, name); err != nil {
+		return err
+	}
+	if err := db.DeleteUserSessions(database, name); err != nil {
+		return err
+	}
+	forgetAuth(name)
+	app.LogIt.Info(fmt.Sprintf("Benutzer %s gelöscht", name))
+	return nil
+}
+
+// keepAdmin verhindert, dass mit name der letzte Administrator ohne
+// Poolbeschränkung verschwindet
+func keepAdmin(database *sql.DB, name string) error {
+	accounts, err := ListAccounts(database)
+	if err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		if a.Name != name && a.IsAdmin() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s ist der letzte Administrator ohne Poolbeschränkung", name)
+}
+
+// ListAccounts liefert die Benutzer aus der Konfiguration und der Datenbank
+func ListAccounts(database *sql.DB) ([]Account, error) {
+	var accounts []Account
+	for _, u := range app.Config.Users {
+		accounts = append(accounts, Account{Name: u.Name, Role: u.Role, Pools: u.Pools, Config: true})
+	}
+	users, err := db.ListUsers(database)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		accounts = append(accounts, Account{Name: u.Username, Role: u.Role, Pools: u.Pools, Source: u.Source})
+	}
+	return accounts, nil
+}
+
+func forgetAuth(name string) {
+	authCacheMu.Lock()
+	delete(authCache, name)
+	authCacheMu.Unlock()
+}
+
+// HasUsers meldet, ob sich überhaupt jemand am Admin-Bereich anmelden kann
+func HasUsers(database *sql.DB) (bool, error) {
+	if len(app.Config.Users) > 0 {
+		return true, nil
+	}
+	users, err := db.ListUsers(database)
+	return len(users) > 0, err
+}
+
+// LookupAccount liefert einen Benutzer aus der Konfiguration oder der
+// Datenbank, nil wenn es ihn nicht gibt
+func LookupAccount(database *sql.DB, name string) (*Account, error) {
+	account, _, err := lookupUser(database, name)
+	return account, err
+}
+
+func lookupUser(database *sql.DB, name string) (*Account, string, error) {
+	if u, ok := configUser(name); ok {
+		return &Account{Name: u.Name, Role: u.Role, Pools: u.Pools, Config: true}, u.PasswordHash, nil
+	}
+	u, err := db.GetUser(database, name)
+	if err != nil || u == nil {
+		return nil, "", err
+	}
+	return &Account{Name: u.Username, Role: u.Role, Pools: u.Pools, Source: u.Source}, u.PasswordHash, nil
+}
+
+// AuthenticateUser prüft Benutzername und Passwort gegen die Konfiguration
+// und danach gegen die Datenbank. Bei falschen Angaben wird nil geliefert.
+func AuthenticateUser(database *sql.DB, name, password string) (*Account, error) {
+	sum := sha256.Sum256([]byte(name + ":" + password))
+	authCacheMu.Lock()
+	cached, ok := authCache[name]
+	authCacheMu.Unlock()
+	if ok && cached.sum == sum && time.Now().Before(cached.until) {
+		return cached.account, nil
+	}
+
+	account, passwordHash, err := lookupUser(database, name)
+	if err != nil {
+		return nil, err
+	}
+	// lokale Benutzer gehen vor, damit ein Notfallkonto auch ohne LDAP bleibt.
+	// OIDC-Benutzer haben kein Passwort und scheitern hier.
+	switch {
+	case account != nil && account.Source != SourceLDAP:
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+			account = nil
+		}
+	case app.Config.LDAP.Enabled:
+		if account, err = ldapLogin(database, name, password); err != nil {
+			return nil, err
+		}
+	default:
+		// unbekannt oder LDAP abgeschaltet, mit gleicher Antwortzeit
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		account = nil
+	}
+	if account == nil {
+		app.LogIt.Info(fmt.Sprintf("Anmeldung von %s fehlgeschlagen", name))
+		return nil, nil
+	}
+
+	authCacheMu.Lock()
+	authCache[name] = authCacheEntry{sum: sum, until: time.Now().Add(authCacheTTL), account: account}
+	authCacheMu.Unlock()
+	if account.Source == SourceLocal {
+		if err := db.TouchUser(database, name, time.Now().Format(timestampLayout)); err != nil {
+			return nil, err
+		}
+	}
+	return account, nil
+}
@@ -0,0 +1,139 @@
+package helpers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// numbered liefert die Zeilen 1 bis n, ersetzt durch replace
+func numbered(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if r, ok := replace[i]; ok {
+			b.WriteString(r + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{
+			name: "gleich",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+		},
+		{
+			name: "neue Datei",
+			old:  "",
+			new:  "a\nb\n",
+			want: []string{"@@ -0,0 +1,2 @@", "+a", "+b"},
+		},
+		{
+			name: "gelöschte Datei",
+			old:  "a\nb\n",
+			new:  "",
+			want: []string{"@@ -1,2 +0,0 @@", "-a", "-b"},
+		},
+		{
+			name: "erste Zeile",
+			old:  numbered(20, nil),
+			new:  numbered(20, map[int]string{1: "x"}),
+			want: []string{"@@ -1,4 +1,4 @@", "-1", "+x", " 2", " 3", " 4"},
+		},
+		{
+			name: "drei Zeilen Kontext",
+			old:  numbered(20, nil),
+			new:  numbered(20, map[int]string{10: "x"}),
+			want: []string{"@@ -7,7 +7,7 @@", " 7", " 8", " 9", "-10", "+x", " 11", " 12", " 13"},
+		},
+		{
+			name: "letzte Zeile angehängt",
+			old:  numbered(20, nil),
+			new:  numbered(21, nil),
+			want: []string{"@@ -18,3 +18,4 @@", " 18", " 19", " 20", "+21"},
+		},
+		{
+			name: "sechs gleiche Zeilen verbinden zwei Hunks",
+			old:  numbered(20, nil),
+			new:  numbered(20, map[int]string{5: "a", 12: "b"}),
+			want: []string{"@@ -2,14 +2,14 @@", " 2", " 3", " 4", "-5", "+a", " 6", " 7", " 8", " 9", " 10", " 11", "-12", "+b", " 13", " 14", " 15"},
+		},
+		{
+			name: "sieben gleiche Zeilen trennen zwei Hunks",
+			old:  numbered(20, nil),
+			new:  numbered(20, map[int]string{5: "a", 13: "b"}),
+			want: []string{
+				"@@ -2,7 +2,7 @@", " 2", " 3", " 4", "-5", "+a", " 6", " 7", " 8",
+				"@@ -10,7 +10,7 @@", " 10", " 11", " 12", "-13", "+b", " 14", " 15", " 16",
+			},
+		},
+		{
+			name: "Zeile eingefügt und entfernt",
+			old:  "a\nb\nc\nd\n",
+			new:  "a\nc\nx\nd\n",
+			want: []string{"@@ -1,4 +1,4 @@", " a", "-b", " c", "+x", " d"},
+		},
+	}
+	for _, tt := range tests {
+		got := UnifiedDiff("alt", "neu", tt.old, tt.new)
+		if tt.want == nil {
+			if got != nil {
+				t.Errorf("%s: UnifiedDiff = %q, erwartet nil", tt.name, got)
+			}
+			continue
+		}
+		want := append([]string{"--- alt", "+++ neu"}, tt.want...)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: UnifiedDiff =\n%s\nerwartet:\n%s", tt.name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestUnifiedDiffMaxCells(t *testing.T) {
+	// mehr als maxDiffCells Vergleiche: der geänderte Block wird als Ganzes
+	// ersetzt, auch wenn er gemeinsame Zeilen enthält
+	var oldLines, newLines []string
+	for i := range 2001 {
+		oldLines = append(oldLines, fmt.Sprintf("alt %d", i))
+		newLines = append(newLines, fmt.Sprintf("neu %d", i))
+	}
+	oldLines[1000], newLines[1000] = "gemeinsam", "gemeinsam"
+	old := "kopf\n" + strings.Join(oldLines, "\n") + "\nfuss\n"
+	new := "kopf\n" + strings.Join(newLines, "\n") + "\nfuss\n"
+	if len(oldLines)*len(newLines) <= maxDiffCells {
+		t.Fatal("Test braucht mehr als maxDiffCells Vergleiche")
+	}
+
+	got := UnifiedDiff("alt", "neu", old, new)
+	if want := "@@ -1,2003 +1,2003 @@"; len(got) < 3 || got[2] != want {
+		t.Fatalf("Kopf des Hunks = %q, erwartet %q", got[2], want)
+	}
+	body := got[3:]
+	if len(body) != 2+2*2001 || body[0] != " kopf" || body[len(body)-1] != " fuss" {
+		t.Fatalf("unerwarteter Hunk mit %d Zeilen", len(body))
+	}
+	for i, line := range body[1 : len(body)-1] {
+		wantKind := byte('-')
+		if i >= 2001 {
+			wantKind = '+'
+		}
+		if line[0] != wantKind {
+			t.Fatalf("Zeile %d = %q, erwartet %c", i, line, wantKind)
+		}
+	}
+
+	// knapp darunter wird die gemeinsame Zeile erkannt
+	small := UnifiedDiff("alt", "neu", strings.Join(oldLines[:1999], "\n"), strings.Join(newLines[:1999], "\n"))
+	if !slices.Contains(small, " gemeinsam") {
+		t.Error("gemeinsame Zeile unterhalb von maxDiffCells nicht erkannt")
+	}
+}
@@ -1,13 +1,16 @@
 package helpers
 
 import (
+	"archive/tar"
 	"bufio"
+	"compress/gzip"
 	"fmt"
 	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 func GetCleanPath(path string) string {
@@ -120,3 +123,116 @@ func CheckSum(hashAlgorithm hash.Hash, filename string) (string, error) {
 		}
 	}
 }
+
+// WriteTarGz schreibt die Dateien (relativ zu baseDir) in ein komprimiertes
+// tar-Archiv. Das Archiv wird erst nach dem vollständigen Schreiben unter
+// archivePath abgelegt.
+func WriteTarGz(archivePath, baseDir string, relFiles []string) error {
+	tmpPath := archivePath + ".tmp"
+	archive, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	gz := gzip.NewWriter(archive)
+	tw := tar.NewWriter(gz)
+	for _, rel := range relFiles {
+		data, err := os.ReadFile(filepath.Join(baseDir, rel))
+		if err != nil {
+			archive.Close()
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(rel),
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			archive.Close()
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			archive.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		archive.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		archive.Close()
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, archivePath)
+}
+
+// ReadTarGz liefert den Inhalt aller Dateien eines komprimierten tar-Archivs
+func ReadTarGz(archivePath string) (map[string][]byte, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+}
+
+// GzipFile komprimiert src nach dst und löscht src anschliessend
+func GzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
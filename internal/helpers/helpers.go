@@ -1,10 +1,21 @@
 package helpers
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"slices"
+	"strings"
+	"unicode"
 )
 
+// maxPoolNameLength begrenzt Poolnamen, sie werden Teil von Datei- und
+// IPSet-Namen
+const maxPoolNameLength = 100
+
+// ErrInvalidPoolName wird für Poolnamen geliefert, die CheckPoolName ablehnt
+var ErrInvalidPoolName = errors.New("ungültiger Poolname")
+
 // ===============
 // general helpers
 // ===============
@@ -19,6 +30,25 @@ func FlagIsPassed(name string) bool {
 	return found
 }
 
+// CheckPoolName prüft den Namen eines Pools. Der Name wird zum Dateinamen der
+// Listen und Exporte, steht in Regeldateien und in der kommagetrennten
+// Poolliste der Benutzer.
+func CheckPoolName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: der Pool braucht einen Namen", ErrInvalidPoolName)
+	case len(name) > maxPoolNameLength:
+		return fmt.Errorf("%w: %q ist länger als %d Zeichen", ErrInvalidPoolName, name, maxPoolNameLength)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("%w: %q darf nicht mit einem Punkt beginnen", ErrInvalidPoolName, name)
+	case strings.ContainsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`/\"',`, r)
+	}):
+		return fmt.Errorf("%w: %q darf weder Leerzeichen noch eines der Zeichen / \\ \" ' , enthalten", ErrInvalidPoolName, name)
+	}
+	return nil
+}
+
 func StringInSlice(name string, sl []string) bool {
 	return slices.Contains(sl, name)
 }
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckPoolName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"scanner", true},
+		{"Kunde_Zürich-2", true},
+		{"spamhaus.drop", true},
+		{strings.Repeat("a", 100), true},
+		{"", false},
+		{strings.Repeat("a", 101), false},
+		{".versteckt", false},
+		{"..", false},
+		{"a/b", false},
+		{"../etc", false},
+		{`a\b`, false},
+		{"mit leerzeichen", false},
+		{"tab\tulator", false},
+		{"zeilen\numbruch", false},
+		{`an"führung`, false},
+		{"a'b", false},
+		{"netz,web", false},
+	}
+	for _, tt := range tests {
+		err := CheckPoolName(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("CheckPoolName(%q) = %v, erwartet gültig", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidPoolName) {
+			t.Errorf("CheckPoolName(%q) = %v, erwartet ErrInvalidPoolName", tt.name, err)
+		}
+	}
+}
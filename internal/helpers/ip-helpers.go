@@ -4,9 +4,15 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
-var ipAtStart = regexp.MustCompile(`^([0-9]{1,3}\.){3}[0-9]{1,3}`)
+var (
+	ipAtStart      = regexp.MustCompile(`^([0-9]{1,3}\.){3}[0-9]{1,3}`)
+	ipRangeAtStart = regexp.MustCompile(`^((?:[0-9]{1,3}\.){3}[0-9]{1,3})\s*-\s*((?:[0-9]{1,3}\.){3}[0-9]{1,3})`)
+	ipRange        = regexp.MustCompile(`^((?:[0-9]{1,3}\.){3}[0-9]{1,3})\s*-\s*((?:[0-9]{1,3}\.){3}[0-9]{1,3})\s*$`)
+)
 
 func StartsWithIP(s string) bool {
 	m := ipAtStart.FindString(s)
@@ -59,3 +65,114 @@ func GetIPRange(cidr string) (uint32, uint32, error) {
 	end := start | (^mask)
 	return start, end, nil
 }
+
+// ApacheHostToCIDR wandelt eine Adressangabe aus Require- oder Allow/Deny-
+// Direktiven in einen CIDR um. Neben vollständigen IPs und CIDRs versteht
+// Apache auch partielle Adressen wie "192.168" oder "10.1." sowie Netzmasken
+// wie "10.0.0.0/255.0.0.0".
+func ApacheHostToCIDR(s string) (string, error) {
+	if addr, mask, found := strings.Cut(s, "/"); found {
+		if strings.Contains(mask, ".") {
+			m := net.ParseIP(mask).To4()
+			if m == nil {
+				return "", fmt.Errorf("ungültige Netzmaske: %s", s)
+			}
+			ones, bits := net.IPMask(m).Size()
+			if bits == 0 {
+				return "", fmt.Errorf("nicht zusammenhängende Netzmaske: %s", s)
+			}
+			s = fmt.Sprintf("%s/%d", addr, ones)
+		}
+		ip, _, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", err
+		}
+		if ip.To4() == nil {
+			return "", fmt.Errorf("nur IPv4 wird unterstützt: %s", s)
+		}
+		return s, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() == nil {
+			return "", fmt.Errorf("nur IPv4 wird unterstützt: %s", s)
+		}
+		return s + "/32", nil
+	}
+	octets := strings.Split(strings.TrimSuffix(s, "."), ".")
+	if len(octets) > 3 {
+		return "", fmt.Errorf("keine gültige IP-Adresse: %s", s)
+	}
+	for _, o := range octets {
+		n, err := strconv.Atoi(o)
+		if err != nil || n < 0 || n > 255 {
+			return "", fmt.Errorf("keine gültige IP-Adresse: %s", s)
+		}
+	}
+	prefix := len(octets) * 8
+	for len(octets) < 4 {
+		octets = append(octets, "0")
+	}
+	return fmt.Sprintf("%s/%d", strings.Join(octets, "."), prefix), nil
+}
+
+// StartsWithIPRange erkennt Bereiche der Form "203.0.113.7 - 203.0.114.200"
+func StartsWithIPRange(s string) bool {
+	return ipRangeAtStart.MatchString(s)
+}
+
+// ParseIPRange liefert Anfang und Ende eines Bereichs der Form
+// "203.0.113.7 - 203.0.114.200". Ausser einem Kommentar nach # darf nichts
+// folgen, damit ein vertippter Bereich nicht als anderer Bereich gilt.
+func ParseIPRange(s string) (uint32, uint32, error) {
+	line, _, _ := strings.Cut(s, "#")
+	m := ipRange.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return 0, 0, fmt.Errorf("kein gültiger IP-Bereich: %s", s)
+	}
+	startIP, endIP := net.ParseIP(m[1]), net.ParseIP(m[2])
+	if startIP == nil || endIP == nil {
+		return 0, 0, fmt.Errorf("kein gültiger IP-Bereich: %s", s)
+	}
+	start, end := IPToUint32(startIP), IPToUint32(endIP)
+	if start > end {
+		return 0, 0, fmt.Errorf("Anfang liegt nach dem Ende des Bereichs: %s", s)
+	}
+	return start, end, nil
+}
+
+// RangeToCIDRs liefert die minimale Liste von CIDRs, die genau den Bereich
+// von start bis end abdeckt.
+func RangeToCIDRs(start, end uint32) []string {
+	var cidrs []string
+	from, to := uint64(start), uint64(end)
+	for from <= to {
+		// grösster Block, der bei from ausgerichtet ist und nicht über to hinausgeht
+		size := uint64(1)
+		prefix := 32
+		for prefix > 0 {
+			next := size << 1
+			if from%next != 0 || from+next-1 > to {
+				break
+			}
+			size = next
+			prefix--
+		}
+		cidrs = append(cidrs, fmt.Sprintf("%s/%d", Uint32ToIP(uint32(from)), prefix))
+		from += size
+	}
+	return cidrs
+}
+
+// ParseIPSpec liefert Anfang und Ende einer Adressangabe: einzelne IP, CIDR,
+// partielle Adresse wie bei Apache ("10.1.") oder Bereich "a - b"
+func ParseIPSpec(s string) (uint32, uint32, error) {
+	s = strings.TrimSpace(s)
+	if StartsWithIPRange(s) {
+		return ParseIPRange(s)
+	}
+	cidr, err := ApacheHostToCIDR(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return GetIPRange(cidr)
+}
@@ -0,0 +1,151 @@
+package helpers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApacheHostToCIDR(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "192.0.2.7", want: "192.0.2.7/32"},
+		{in: "192.0.2.0/24", want: "192.0.2.0/24"},
+		{in: "10.1.2.3/8", want: "10.1.2.3/8"},
+		// partielle Adressen wie bei Apache
+		{in: "10", want: "10.0.0.0/8"},
+		{in: "10.", want: "10.0.0.0/8"},
+		{in: "192.168", want: "192.168.0.0/16"},
+		{in: "10.1.", want: "10.1.0.0/16"},
+		{in: "172.16.5.", want: "172.16.5.0/24"},
+		// Netzmasken
+		{in: "10.0.0.0/255.0.0.0", want: "10.0.0.0/8"},
+		{in: "192.168.1.0/255.255.255.0", want: "192.168.1.0/24"},
+		{in: "192.0.2.1/255.255.255.255", want: "192.0.2.1/32"},
+		{in: "10.0.0.0/255.0.255.0", wantErr: true},
+		{in: "10.0.0.0/255.0.0", wantErr: true},
+		// ungültig
+		{in: "", wantErr: true},
+		{in: "256.1", wantErr: true},
+		{in: "10.1.2.3.4", wantErr: true},
+		{in: "10.a", wantErr: true},
+		{in: "10.0.0.0/33", wantErr: true},
+		{in: "2001:db8::1", wantErr: true},
+		{in: "2001:db8::/32", wantErr: true},
+		{in: "example.org", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ApacheHostToCIDR(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ApacheHostToCIDR(%q) = %q, erwartet Fehler", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ApacheHostToCIDR(%q) = %q, %v, erwartet %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end string
+		wantErr    bool
+	}{
+		{in: "203.0.113.7 - 203.0.114.200", start: "203.0.113.7", end: "203.0.114.200"},
+		{in: "203.0.113.7-203.0.113.7", start: "203.0.113.7", end: "203.0.113.7"},
+		{in: "  10.0.0.0 -10.0.0.255  ", start: "10.0.0.0", end: "10.0.0.255"},
+		{in: "0.0.0.0-255.255.255.255", start: "0.0.0.0", end: "255.255.255.255"},
+		{in: "10.0.0.1 - 10.0.0.5 # Abuse-Meldung", start: "10.0.0.1", end: "10.0.0.5"},
+		// vertippte Bereiche dürfen nicht gekürzt übernommen werden
+		{in: "10.0.0.1 - 10.0.0.1000", wantErr: true},
+		{in: "10.0.0.1-10.0.0.20x", wantErr: true},
+		{in: "10.0.0.1 - 10.0.0.5 10.0.0.9", wantErr: true},
+		{in: "10.0.0.1 - 10.0.0.5 - 10.0.0.9", wantErr: true},
+		{in: "203.0.114.200 - 203.0.113.7", wantErr: true},
+		{in: "203.0.113.7 - 203.0.113", wantErr: true},
+		{in: "203.0.113.300 - 203.0.114.1", wantErr: true},
+		{in: "203.0.113.0/24", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseIPRange(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseIPRange(%q) = %s - %s, erwartet Fehler", tt.in, Uint32ToIP(start), Uint32ToIP(end))
+			}
+			continue
+		}
+		if err != nil || Uint32ToIP(start) != tt.start || Uint32ToIP(end) != tt.end {
+			t.Errorf("ParseIPRange(%q) = %s - %s, %v, erwartet %s - %s", tt.in, Uint32ToIP(start), Uint32ToIP(end), err, tt.start, tt.end)
+		}
+	}
+}
+
+func TestRangeToCIDRs(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       []string
+	}{
+		{"192.0.2.7", "192.0.2.7", []string{"192.0.2.7/32"}},
+		{"192.0.2.0", "192.0.2.255", []string{"192.0.2.0/24"}},
+		{"192.0.2.1", "192.0.2.6", []string{"192.0.2.1/32", "192.0.2.2/31", "192.0.2.4/31", "192.0.2.6/32"}},
+		{"203.0.113.7", "203.0.114.200", []string{
+			"203.0.113.7/32", "203.0.113.8/29", "203.0.113.16/28", "203.0.113.32/27",
+			"203.0.113.64/26", "203.0.113.128/25", "203.0.114.0/25", "203.0.114.128/26",
+			"203.0.114.192/29", "203.0.114.200/32",
+		}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"255.255.255.255", "255.255.255.255", []string{"255.255.255.255/32"}},
+		{"0.0.0.1", "255.255.255.255", []string{
+			"0.0.0.1/32", "0.0.0.2/31", "0.0.0.4/30", "0.0.0.8/29", "0.0.0.16/28", "0.0.0.32/27",
+			"0.0.0.64/26", "0.0.0.128/25", "0.0.1.0/24", "0.0.2.0/23", "0.0.4.0/22", "0.0.8.0/21",
+			"0.0.16.0/20", "0.0.32.0/19", "0.0.64.0/18", "0.0.128.0/17", "0.1.0.0/16", "0.2.0.0/15",
+			"0.4.0.0/14", "0.8.0.0/13", "0.16.0.0/12", "0.32.0.0/11", "0.64.0.0/10", "0.128.0.0/9",
+			"1.0.0.0/8", "2.0.0.0/7", "4.0.0.0/6", "8.0.0.0/5", "16.0.0.0/4", "32.0.0.0/3",
+			"64.0.0.0/2", "128.0.0.0/1",
+		}},
+	}
+	for _, tt := range tests {
+		start, _, _ := GetIPRange(tt.start + "/32")
+		end, _, _ := GetIPRange(tt.end + "/32")
+		got := RangeToCIDRs(start, end)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("RangeToCIDRs(%s, %s) = %v, erwartet %v", tt.start, tt.end, got, tt.want)
+		}
+		// die CIDRs decken den Bereich lückenlos ab
+		next := uint64(start)
+		for _, cidr := range got {
+			s, e, err := GetIPRange(cidr)
+			if err != nil || uint64(s) != next {
+				t.Errorf("RangeToCIDRs(%s, %s): %s schliesst nicht an", tt.start, tt.end, cidr)
+				break
+			}
+			next = uint64(e) + 1
+		}
+		if next != uint64(end)+1 {
+			t.Errorf("RangeToCIDRs(%s, %s) endet nicht bei %s", tt.start, tt.end, tt.end)
+		}
+	}
+}
+
+func TestParseIPSpec(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end string
+	}{
+		{"192.0.2.7", "192.0.2.7", "192.0.2.7"},
+		{"10.1.", "10.1.0.0", "10.1.255.255"},
+		{"192.0.2.0/24", "192.0.2.0", "192.0.2.255"},
+		{"192.0.2.9 - 192.0.2.20", "192.0.2.9", "192.0.2.20"},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseIPSpec(tt.in)
+		if err != nil || Uint32ToIP(start) != tt.start || Uint32ToIP(end) != tt.end {
+			t.Errorf("ParseIPSpec(%q) = %s - %s, %v, erwartet %s - %s", tt.in, Uint32ToIP(start), Uint32ToIP(end), err, tt.start, tt.end)
+		}
+	}
+}
@@ -0,0 +1,626 @@
+package webserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/functions"
+	"github.com/SvenKethz/fairdb/internal/helpers"
+)
+
+// maximale Grösse eines Imports über die API
+const maxAPIImportSize = 32 << 20
+
+// apiEntry ist ein Pool-Eintrag in den Antworten der API
+type apiEntry struct {
+	ID      int    `json:"id"`
+	Pool    string `json:"pool"`
+	CIDR    string `json:"cidr"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Comment string `json:"comment"`
+	Status  string `json:"status"`
+	Group   int64  `json:"group,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// apiPool ist ein Pool mit der Anzahl Einträge je Status
+type apiPool struct {
+	Name        string     `json:"name"`
+	Whitelisted int        `json:"whitelisted"`
+	Blocked     int        `json:"blocked"`
+	Total       int        `json:"total"`
+	Entries     []apiEntry `json:"entries,omitempty"`
+}
+
+// apiCheckResult ist eine geprüfte Angabe mit allen Einträgen, die sie berühren
+type apiCheckResult struct {
+	Input   string     `json:"input"`
+	Start   string     `json:"start,omitempty"`
+	End     string     `json:"end,omitempty"`
+	Found   bool       `json:"found"`
+	Error   string     `json:"error,omitempty"`
+	Entries []apiEntry `json:"entries"`
+}
+
+// apiErrorBody ist der Aufbau aller Fehlerantworten
+type apiErrorBody struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Entry   *apiEntry `json:"entry,omitempty"`
+}
+
+func toAPIEntry(e db.PoolEntry) apiEntry {
+	return apiEntry{
+		ID:      e.ID,
+		Pool:    e.Name,
+		CIDR:    e.CIDR,
+		Start:   helpers.Uint32ToIP(e.StartIPInt),
+		End:     helpers.Uint32ToIP(e.EndIPInt),
+		Comment: e.Comment,
+		Status:  e.Status,
+		Group:   e.GroupID,
+		Expires: e.ExpiresAt,
+	}
+}
+
+func toAPIEntries(entries []db.PoolEntry) []apiEntry {
+	res := make([]apiEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, toAPIEntry(e))
+	}
+	return res
+}
+
+func toAPIPool(name string, entries []db.PoolEntry) apiPool {
+	wCount, bCount := functions.GetStatusCount(entries)
+	return apiPool{Name: name, Whitelisted: wCount, Blocked: bCount, Total: len(entries)}
+}
+
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": apiErrorBody{Code: code, Message: message}})
+}
+
+// apiLog hält Änderungen über die API mit dem angemeldeten Benutzer fest
+func apiLog(c *gin.Context, msg string) {
+	app.LogIt.Info(fmt.Sprintf("API %s: %s", currentUser(c), msg))
+}
+
+// Schlüssel des API-Tokens im gin.Context
+const apiTokenKey = "apiToken"
+
+// apiAuth meldet Anfragen mit "Authorization: Bearer <token>" über ein
+// API-Token an, alle anderen per BasicAuth wie im Admin-Bereich
+func apiAuth(database *sql.DB) gin.HandlerFunc {
+	basicAuth := userAuth(database)
+	return func(c *gin.Context) {
+		plain, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found {
+			basicAuth(c)
+			return
+		}
+		token, err := functions.AuthenticateToken(database, strings.TrimSpace(plain))
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		if token == nil {
+			apiError(c, http.StatusUnauthorized, "unauthorized", "unbekanntes oder abgelaufenes Token")
+			return
+		}
+		c.Set(gin.AuthUserKey, "token:"+token.Name)
+		c.Set(apiTokenKey, token)
+	}
+}
+
+// apiRequestLog hält jede Anfrage an die API mit dem angemeldeten Benutzer
+// bzw. Token fest
+func apiRequestLog(c *gin.Context) {
+	c.Next()
+	app.LogIt.Info(fmt.Sprintf("API %s: %s %s -> %d", currentUser(c), c.Request.Method, c.Request.URL.Path, c.Writer.Status()))
+}
+
+// apiToken liefert das Token der Anfrage, nil bei BasicAuth
+func apiToken(c *gin.Context) *db.APIToken {
+	if t, ok := c.Get(apiTokenKey); ok {
+		return t.(*db.APIToken)
+	}
+	return nil
+}
+
+// Rolle, die ein Benutzer per BasicAuth für einen Scope braucht
+var scopeRoles = map[string]string{
+	"read":     functions.RoleViewer,
+	"check":    functions.RoleViewer,
+	"write":    functions.RoleEditor,
+	"activate": functions.RoleAdmin,
+}
+
+// requireScope lässt Tokens nur mit dem angegebenen Scope durch und prüft bei
+// Pfaden mit :name, ob das Token für den Pool gilt. Benutzer brauchen die
+// entsprechende Rolle, bei Einträgen wird der Pool in apiEntryByID geprüft.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := apiToken(c)
+		if token == nil {
+			pool := c.Param("name")
+			if pool == "" && scope == "write" {
+				if !currentAccount(c).HasRole(functions.RoleEditor) {
+					apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("dem Benutzer %s fehlt die Rolle %s", currentUser(c), functions.RoleEditor))
+				}
+				return
+			}
+			apiRequireRole(c, scopeRoles[scope], pool)
+			return
+		}
+		if !token.HasScope(scope) {
+			apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("dem Token %s fehlt der Scope %s", token.Name, scope))
+			return
+		}
+		if pool := c.Param("name"); pool != "" && !token.AllowsPool(pool) {
+			apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("das Token %s gilt nicht für Pool %s", token.Name, pool))
+		}
+	}
+}
+
+// apiRequireRole beendet die Anfrage, wenn der angemeldete Benutzer nicht mit
+// role im Pool handeln darf; für Tokens gelten nur die Scopes
+func apiRequireRole(c *gin.Context, role, pool string) bool {
+	if apiToken(c) != nil || currentAccount(c).Can(role, pool) {
+		return true
+	}
+	if pool == "" {
+		apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("der Benutzer %s braucht die Rolle %s für alle Pools", currentUser(c), role))
+	} else {
+		apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("der Benutzer %s braucht die Rolle %s im Pool %s", currentUser(c), role, pool))
+	}
+	return false
+}
+
+// registerAPI hängt die JSON-API unter api an
+func registerAPI(api *gin.RouterGroup, database *sql.DB) {
+	api.Use(apiRequestLog, apiAuth(database))
+	read, check, write, activate := requireScope("read"), requireScope("check"), requireScope("write"), requireScope("activate")
+	// Löschen und Importieren ist Benutzern mit der Rolle admin im Pool vorbehalten
+	poolAdmin := func(c *gin.Context) {
+		apiRequireRole(c, functions.RoleAdmin, c.Param("name"))
+	}
+
+	// Pools
+	api.GET("/pools", read, func(c *gin.Context) {
+		names, err := db.ListPoolNames(database)
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		pools := make([]apiPool, 0, len(names))
+		for _, name := range names {
+			if token := apiToken(c); token != nil && !token.AllowsPool(name) {
+				continue
+			}
+			entries, err := db.ListByPool(database, name)
+			if err != nil {
+				apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+				return
+			}
+			pools = append(pools, toAPIPool(name, entries))
+		}
+		c.JSON(http.StatusOK, gin.H{"pools": pools})
+	})
+	api.GET("/pools/:name", read, func(c *gin.Context) {
+		entries, ok := apiPoolEntries(c, database)
+		if !ok {
+			return
+		}
+		pool := toAPIPool(c.Param("name"), entries)
+		pool.Entries = toAPIEntries(entries)
+		c.JSON(http.StatusOK, pool)
+	})
+	api.GET("/pools/:name/entries", read, func(c *gin.Context) {
+		entries, ok := apiPoolEntries(c, database)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": toAPIEntries(entries)})
+	})
+	api.POST("/pools/:name/entries", write, func(c *gin.Context) {
+		poolName := c.Param("name")
+		var req struct {
+			CIDR    string `json:"cidr"`
+			Comment string `json:"comment"`
+			Status  string `json:"status"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		cidr := strings.TrimSpace(req.CIDR)
+		if cidr == "" {
+			apiError(c, http.StatusBadRequest, "invalid_cidr", "cidr fehlt")
+			return
+		}
+		status := req.Status
+		if status == "" {
+			status = "b"
+		}
+		if status != "w" && status != "b" {
+			apiError(c, http.StatusBadRequest, "invalid_status", "status muss w oder b sein")
+			return
+		}
+		var existing *db.PoolEntry
+		var err error
+		if helpers.StartsWithIPRange(cidr) {
+			start, end, rangeErr := helpers.ParseIPRange(cidr)
+			if rangeErr != nil {
+				apiError(c, http.StatusBadRequest, "invalid_cidr", rangeErr.Error())
+				return
+			}
+			existing, err = db.InsertRangeEntry(database, helpers.RangeToCIDRs(start, end), poolName, strings.TrimSpace(req.Comment), status)
+		} else {
+			existing, err = db.InsertEntry(database, cidr, poolName, strings.TrimSpace(req.Comment), status)
+		}
+		if errors.Is(err, helpers.ErrInvalidPoolName) {
+			apiError(c, http.StatusBadRequest, "invalid_pool", err.Error())
+			return
+		}
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_cidr", err.Error())
+			return
+		}
+		if existing != nil {
+			entry := toAPIEntry(*existing)
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": apiErrorBody{
+				Code:    "conflict",
+				Message: fmt.Sprintf("%s überschneidet sich mit %s in Pool %s", cidr, existing.CIDR, existing.Name),
+				Entry:   &entry,
+			}})
+			return
+		}
+		apiLog(c, fmt.Sprintf("%s in Pool %s angelegt (Status %s)", cidr, poolName, status))
+		entries, err := db.ListByPool(database, poolName)
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"entries": toAPIEntries(entries)})
+	})
+	api.POST("/pools/:name/whitelist", write, func(c *gin.Context) {
+		poolName := c.Param("name")
+		if _, ok := apiPoolEntries(c, database); !ok {
+			return
+		}
+		conflicts, err := db.WhitelistPool(database, poolName)
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		if conflicts != nil {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error":     apiErrorBody{Code: "conflict", Message: fmt.Sprintf("%d Einträge sind in anderen Pools geblockt", len(conflicts))},
+				"conflicts": toAPIEntries(conflicts),
+			})
+			return
+		}
+		apiLog(c, fmt.Sprintf("Pool %s gewhitelistet", poolName))
+		apiPoolResponse(c, database, poolName)
+	})
+	api.POST("/pools/:name/block", write, func(c *gin.Context) {
+		poolName := c.Param("name")
+		if _, ok := apiPoolEntries(c, database); !ok {
+			return
+		}
+		if err := db.BlockPool(database, poolName); err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		apiLog(c, fmt.Sprintf("Pool %s geblockt", poolName))
+		apiPoolResponse(c, database, poolName)
+	})
+	api.DELETE("/pools/:name", write, poolAdmin, func(c *gin.Context) {
+		poolName := c.Param("name")
+		if _, ok := apiPoolEntries(c, database); !ok {
+			return
+		}
+		if err := db.DeletePool(database, poolName); err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		apiLog(c, fmt.Sprintf("Pool %s gelöscht", poolName))
+		c.Status(http.StatusNoContent)
+	})
+	// Import einer Liste als Body oder als Datei im Feld "file"
+	api.POST("/pools/:name/import", write, poolAdmin, func(c *gin.Context) {
+		poolName := c.Param("name")
+		status := c.Query("status")
+		if status != "" && status != "w" && status != "b" {
+			apiError(c, http.StatusBadRequest, "invalid_status", "status muss w oder b sein")
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAPIImportSize)
+		var body io.Reader = c.Request.Body
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			fileHeader, err := c.FormFile("file")
+			if err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", "Datei im Feld file fehlt")
+				return
+			}
+			f, err := fileHeader.Open()
+			if err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+				return
+			}
+			defer f.Close()
+			body = f
+		}
+		result, err := functions.ImportConf(database, body, poolName, status)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "import_failed", err.Error())
+			return
+		}
+		apiLog(c, fmt.Sprintf("%d Einträge in Pool %s importiert", result.Imported, poolName))
+		unparsed := result.Unparsed
+		if unparsed == nil {
+			unparsed = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"pool": poolName, "imported": result.Imported, "unparsed": unparsed})
+	})
+	// Pool nach outputPath exportieren
+	api.POST("/pools/:name/export", write, func(c *gin.Context) {
+		poolName := c.Param("name")
+		if _, ok := apiPoolEntries(c, database); !ok {
+			return
+		}
+		wCount, bCount, err := functions.ExportConf(database, poolName, app.Config.OutputPath)
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "export_failed", err.Error())
+			return
+		}
+		apiLog(c, fmt.Sprintf("Pool %s nach %s exportiert", poolName, app.Config.OutputPath))
+		c.JSON(http.StatusOK, gin.H{"pool": poolName, "path": app.Config.OutputPath, "whitelisted": wCount, "blocked": bCount})
+	})
+
+	// einzelne Einträge, mit ?group=true für den ganzen importierten Bereich
+	api.GET("/entries/:id", read, func(c *gin.Context) {
+		entry, ok := apiEntryByID(c, database)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, toAPIEntry(*entry))
+	})
+	entryAction := func(action string, byID, byGroup func(*sql.DB, string) error) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			entry, ok := apiEntryByID(c, database)
+			if !ok || !apiRequireRole(c, functions.RoleEditor, entry.Name) {
+				return
+			}
+			var err error
+			if c.Query("group") == "true" && entry.GroupID != 0 {
+				err = byGroup(database, strconv.FormatInt(entry.GroupID, 10))
+			} else {
+				err = byID(database, c.Param("id"))
+			}
+			if err != nil {
+				apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+				return
+			}
+			apiLog(c, fmt.Sprintf("%s in Pool %s %s", entry.CIDR, entry.Name, action))
+			if action == "gelöscht" {
+				c.Status(http.StatusNoContent)
+				return
+			}
+			if entry, ok = apiEntryByID(c, database); ok {
+				c.JSON(http.StatusOK, toAPIEntry(*entry))
+			}
+		}
+	}
+	api.POST("/entries/:id/whitelist", write, entryAction("gewhitelistet", db.WhitelistByID, db.WhitelistByGroup))
+	api.POST("/entries/:id/block", write, entryAction("geblockt", db.BlockByID, db.BlockByGroup))
+	api.DELETE("/entries/:id", write, entryAction("gelöscht", db.DeleteByID, db.DeleteByGroup))
+
+	// Aktivierung; von Hand geänderte Listen werden nur mit overwriteDrift überschrieben
+	api.POST("/activate", activate, func(c *gin.Context) {
+		// die Aktivierung schreibt alle Pools
+		if token := apiToken(c); token != nil && len(token.Pools) > 0 {
+			apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("das Token %s ist auf einzelne Pools beschränkt und darf nicht aktivieren", token.Name))
+			return
+		}
+		var req struct {
+			OverwriteDrift bool `json:"overwriteDrift"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+				return
+			}
+		}
+		if !req.OverwriteDrift {
+			drifted, err := functions.DetectDrift(database)
+			if err != nil {
+				apiError(c, http.StatusInternalServerError, "drift_check_failed", err.Error())
+				return
+			}
+			if len(drifted) > 0 {
+				files := make([]string, 0, len(drifted))
+				for _, d := range drifted {
+					files = append(files, d.File)
+				}
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+					"error":   apiErrorBody{Code: "drift", Message: "Listen wurden von Hand geändert - overwriteDrift setzen oder zuerst übernehmen"},
+					"drifted": files,
+				})
+				return
+			}
+		}
+		result, err := functions.ExportDB2Conf(database)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error":  apiErrorBody{Code: "activation_failed", Message: err.Error()},
+				"result": result,
+			})
+			return
+		}
+		apiLog(c, fmt.Sprintf("Listen aktiviert (%d Dateien)", len(result.Files)))
+		c.JSON(http.StatusOK, result)
+	})
+
+	// Suche über alle Pools, beschränkte Tokens sehen nur ihre Pools
+	api.GET("/search", read, func(c *gin.Context) {
+		q, err := searchQuery(c)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_query", err.Error())
+			return
+		}
+		if token := apiToken(c); token != nil {
+			q.Pools = token.Pools
+		}
+		res, err := functions.Search(database, q)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_query", err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"total":   res.Total,
+			"page":    res.Page,
+			"pages":   res.Pages,
+			"perPage": res.PerPage,
+			"entries": toAPIEntries(res.Entries),
+		})
+	})
+
+	// Prüfen, ob eine IP registriert ist
+	api.GET("/check", check, func(c *gin.Context) {
+		ipStr := strings.TrimSpace(c.Query("ip"))
+		parsed := net.ParseIP(ipStr)
+		if parsed == nil || parsed.To4() == nil {
+			apiError(c, http.StatusBadRequest, "invalid_ip", fmt.Sprintf("ungültige IPv4-Adresse: %q", ipStr))
+			return
+		}
+		found, err := db.FindPoolByIP(database, helpers.IPToUint32(parsed))
+		if err != nil {
+			apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+			return
+		}
+		if found == nil {
+			c.JSON(http.StatusOK, gin.H{"ip": ipStr, "found": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ip": ipStr, "found": true, "entry": toAPIEntry(*found)})
+	})
+	// viele Adressen, CIDRs oder Bereiche auf einmal prüfen: als JSON
+	// {"addresses": [...]}, als Text im Body oder als Datei im Feld "file"
+	api.POST("/check", check, func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCheckSize)
+		var body io.Reader = c.Request.Body
+		switch {
+		case c.ContentType() == "application/json":
+			var req struct {
+				Addresses []string `json:"addresses"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+				return
+			}
+			body = strings.NewReader(strings.Join(req.Addresses, "\n"))
+		case strings.HasPrefix(c.ContentType(), "multipart/"):
+			fileHeader, err := c.FormFile("file")
+			if err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", "Datei im Feld file fehlt")
+				return
+			}
+			f, err := fileHeader.Open()
+			if err != nil {
+				apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+				return
+			}
+			defer f.Close()
+			body = f
+		}
+		// beschränkte Tokens sehen nur Treffer in ihren Pools
+		var pools []string
+		if token := apiToken(c); token != nil {
+			pools = token.Pools
+		}
+		report, err := functions.CheckAddresses(database, body, pools)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, "invalid_body", err.Error())
+			return
+		}
+		if c.Query("format") == "csv" {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			if err := functions.WriteCheckCSV(c.Writer, report.Results); err != nil {
+				app.LogIt.Error(fmt.Sprintf("CSV der Prüfung konnte nicht geschrieben werden: %v", err))
+			}
+			return
+		}
+		results := make([]apiCheckResult, 0, len(report.Results))
+		for _, res := range report.Results {
+			r := apiCheckResult{Input: res.Input, Found: len(res.Entries) > 0, Error: res.Error, Entries: toAPIEntries(res.Entries)}
+			if res.Error == "" {
+				r.Start, r.End = helpers.Uint32ToIP(res.Start), helpers.Uint32ToIP(res.End)
+			}
+			results = append(results, r)
+		}
+		unparsed := report.Unparsed
+		if unparsed == nil {
+			unparsed = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{"checked": len(results), "found": report.Found, "results": results, "unparsed": unparsed})
+	})
+}
+
+// apiPoolEntries liefert die Einträge des Pools aus dem Pfad und antwortet
+// mit 404, wenn es den Pool nicht gibt
+func apiPoolEntries(c *gin.Context, database *sql.DB) ([]db.PoolEntry, bool) {
+	entries, err := db.ListByPool(database, c.Param("name"))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+		return nil, false
+	}
+	if len(entries) == 0 {
+		apiError(c, http.StatusNotFound, "not_found", fmt.Sprintf("Pool %s gibt es nicht", c.Param("name")))
+		return nil, false
+	}
+	return entries, true
+}
+
+func apiPoolResponse(c *gin.Context, database *sql.DB, poolName string) {
+	entries, err := db.ListByPool(database, poolName)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toAPIPool(poolName, entries))
+}
+
+// apiEntryByID liefert den Eintrag aus dem Pfad und antwortet mit 404, wenn es
+// ihn nicht gibt
+func apiEntryByID(c *gin.Context, database *sql.DB) (*db.PoolEntry, bool) {
+	if _, err := strconv.Atoi(c.Param("id")); err != nil {
+		apiError(c, http.StatusBadRequest, "invalid_id", "id muss eine Zahl sein")
+		return nil, false
+	}
+	entry, err := db.GetEntry(database, c.Param("id"))
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, "db_error", err.Error())
+		return nil, false
+	}
+	if entry == nil {
+		apiError(c, http.StatusNotFound, "not_found", fmt.Sprintf("Eintrag %s gibt es nicht", c.Param("id")))
+		return nil, false
+	}
+	if token := apiToken(c); token != nil && !token.AllowsPool(entry.Name) {
+		apiError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("das Token %s gilt nicht für Pool %s", token.Name, entry.Name))
+		return nil, false
+	}
+	return entry, true
+}
@@ -0,0 +1,211 @@
+package webserver
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	app "github.com/SvenKethz/fairdb/internal/configuration"
+	"github.com/SvenKethz/fairdb/internal/db"
+	"github.com/SvenKethz/fairdb/internal/functions"
+)
+
+// Schlüssel des angemeldeten Benutzers und der Session im gin.Context
+const (
+	accountKey    = "account"
+	sessionKey    = "session"
+	sessionCookie = "blv_session"
+	// bindet eine OIDC-Anmeldung an den Browser, der sie begonnen hat
+	oidcStateCookie = "blv_oidc_state"
+)
+
+// userAuth meldet Anfragen per BasicAuth mit den Benutzern aus Konfiguration
+// und Datenbank an. Der Benutzername steht danach unter gin.AuthUserKey, der
+// Benutzer mit Rolle unter accountKey.
+func userAuth(database *sql.DB) gin.HandlerFunc {
+	realm := "Basic realm=" + strconv.Quote(app.ApplicationName)
+	return func(c *gin.Context) {
+		user, password, ok := c.Request.BasicAuth()
+		if ok {
+			account, err := functions.AuthenticateUser(database, user, password)
+			if err != nil {
+				app.LogIt.Error(fmt.Sprintf("Anmeldung von %s konnte nicht geprüft werden: %v", user, err))
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			if account != nil {
+				c.Set(gin.AuthUserKey, user)
+				c.Set(accountKey, account)
+				return
+			}
+		}
+		c.Header("WWW-Authenticate", realm)
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// sessionAuth lässt im Admin-Bereich nur angemeldete Benutzer mit gültiger
+// Session durch, alle anderen landen auf der Anmeldeseite
+func sessionAuth(database *sql.DB, BasePath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, err := c.Cookie(sessionCookie); err == nil && id != "" {
+			session, err := functions.ValidateSession(database, id)
+			if err != nil {
+				app.LogIt.Error(fmt.Sprintf("Session konnte nicht geprüft werden: %v", err))
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			if session != nil {
+				c.Set(gin.AuthUserKey, session.Account.Name)
+				c.Set(accountKey, session.Account)
+				c.Set(sessionKey, session)
+				return
+			}
+		}
+		next := ""
+		if c.Request.Method == http.MethodGet {
+			next = "?next=" + url.QueryEscape(c.Request.URL.RequestURI())
+		}
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/login"+next)
+		c.Abort()
+	}
+}
+
+// csrfProtect verlangt bei allen Anfragen ausser GET das CSRF-Token der
+// Session im Formularfeld csrf oder im Header X-CSRF-Token
+func csrfProtect(BasePath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			return
+		}
+		token := c.GetHeader("X-CSRF-Token")
+		if token == "" {
+			token = c.PostForm("csrf")
+		}
+		if s, ok := c.Get(sessionKey); !ok || !s.(*functions.SessionInfo).CheckCSRF(token) {
+			forbidden(c, BasePath, "Ungültiges oder fehlendes CSRF-Token - bitte die Seite neu laden.")
+		}
+	}
+}
+
+// setSessionCookie setzt das Session-Cookie, mit maxAge < 0 wird es gelöscht
+func setSessionCookie(c *gin.Context, id string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !app.Config.Session.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setOIDCStateCookie hält den state einer OIDC-Anmeldung bis zum Rücksprung.
+// SameSite Lax, weil der Rücksprung vom Identity Provider ein Top-Level-GET ist.
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !app.Config.Session.InsecureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeNext lässt als Ziel nach der Anmeldung nur Pfade auf diesem Server zu
+func safeNext(next, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
+
+// currentUser liefert den angemeldeten Benutzer
+func currentUser(c *gin.Context) string {
+	return c.GetString(gin.AuthUserKey)
+}
+
+// currentAccount liefert den angemeldeten Benutzer, nil bei API-Tokens
+func currentAccount(c *gin.Context) *functions.Account {
+	if a, ok := c.Get(accountKey); ok {
+		return a.(*functions.Account)
+	}
+	return nil
+}
+
+// pageData ergänzt die Daten einer Seite um den angemeldeten Benutzer, damit
+// die Templates nur erlaubte Aktionen anzeigen ({{ if .account.CanEdit .pool }}),
+// und um das CSRF-Token für Formulare
+func pageData(c *gin.Context, h gin.H) gin.H {
+	h["account"] = currentAccount(c)
+	if s, ok := c.Get(sessionKey); ok {
+		h["csrf"] = s.(*functions.SessionInfo).CSRF
+	}
+	return h
+}
+
+// forbidden beendet die Anfrage mit einem Hinweis auf die fehlende Berechtigung
+func forbidden(c *gin.Context, BasePath, msg string) {
+	app.LogIt.Info(fmt.Sprintf("Admin %s: %s %s verweigert", currentUser(c), c.Request.Method, c.Request.URL.Path))
+	c.HTML(http.StatusForbidden, "forbidden.html", pageData(c, gin.H{
+		"title":    "Keine Berechtigung",
+		"error":    msg,
+		"BasePath": BasePath,
+	}))
+	c.Abort()
+}
+
+// requireRole lässt nur Benutzer mit role ohne Poolbeschränkung durch, für
+// Aktionen, die alle Pools betreffen
+func requireRole(BasePath, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !currentAccount(c).Can(role, "") {
+			forbidden(c, BasePath, fmt.Sprintf("Dafür ist die Rolle %s für alle Pools nötig.", role))
+		}
+	}
+}
+
+// requirePoolRole lässt nur Benutzer durch, die mit role im Pool :name
+// handeln dürfen
+func requirePoolRole(BasePath, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pool := c.Param("name")
+		if !currentAccount(c).Can(role, pool) {
+			forbidden(c, BasePath, fmt.Sprintf("Dafür ist die Rolle %s im Pool %s nötig.", role, pool))
+		}
+	}
+}
+
+// requirePoolEntry stellt sicher, dass entryID bzw. groupID aus dem Formular
+// zum Pool :name gehören, damit die Berechtigung im Pool nicht umgangen wird
+func requirePoolEntry(database *sql.DB, BasePath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pool := c.Param("name")
+		n, err := db.CountOutsidePool(database, pool, c.PostForm("entryID"), c.PostForm("groupID"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if n > 0 {
+			forbidden(c, BasePath, fmt.Sprintf("Der Eintrag gehört nicht zum Pool %s.", pool))
+		}
+	}
+}
+
+// adminRequestLog hält jede Änderung im Admin-Bereich mit dem angemeldeten
+// Benutzer fest
+func adminRequestLog(c *gin.Context) {
+	c.Next()
+	if c.Request.Method == http.MethodGet {
+		return
+	}
+	app.LogIt.Info(fmt.Sprintf("Admin %s: %s %s -> %d", currentUser(c), c.Request.Method, c.Request.URL.Path, c.Writer.Status()))
+}
@@ -2,10 +2,14 @@ package webserver
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"net"
+	"html/template"
+	"io"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -16,9 +20,16 @@ import (
 	"github.com/SvenKethz/fairdb/internal/helpers"
 )
 
+// maximale Grösse einer Prüfung mit hochgeladener Liste
+const maxCheckSize = 2 << 20
+
 func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 	dr := gin.Default()
 	dr.SetTrustedProxies(app.Config.TrustedProxies)
+	dr.SetFuncMap(template.FuncMap{
+		// Anzahl Adressen eines Eintrags
+		"rangeSize": func(start, end uint32) uint64 { return uint64(end) - uint64(start) + 1 },
+	})
 	dr.LoadHTMLGlob(app.Config.WebfilesPath + "templates/*.html")
 	r := dr.Group(BasePath)
 	// Statische Dateien bereitstellen
@@ -32,68 +43,70 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 		})
 	})
 
+	// Prüfen einer oder vieler Adressen aus dem Feld ip oder einer Datei;
+	// mit format=csv kommt die Tabelle als Download
 	r.POST("/check", func(c *gin.Context) {
-		ipStr := strings.TrimSpace(c.PostForm("ip"))
-		if ipStr == "" {
-			c.HTML(http.StatusBadRequest, "index.html", gin.H{
-				"title":    "IP Blocklist Manager",
-				"error":    "Bitte eine IP-Adresse eingeben.",
-				"BasePath": BasePath,
-			})
-			return
+		renderIndex := func(status int, h gin.H) {
+			h["title"] = "IP Blocklist Manager"
+			h["BasePath"] = BasePath
+			c.HTML(status, "index.html", h)
 		}
-		parsed := net.ParseIP(ipStr)
-		if parsed == nil {
-			c.HTML(http.StatusBadRequest, "index.html", gin.H{
-				"title":    "IP Blocklist Manager",
-				"error":    fmt.Sprintf("Ungültige IP-Adresse: %s", ipStr),
-				"BasePath": BasePath,
-			})
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCheckSize)
+		var input io.Reader = strings.NewReader(c.PostForm("ip"))
+		if fileHeader, err := c.FormFile("file"); err == nil {
+			f, err := fileHeader.Open()
+			if err != nil {
+				renderIndex(http.StatusBadRequest, gin.H{"error": "Fehler beim Öffnen der Datei."})
+				return
+			}
+			defer f.Close()
+			input = io.MultiReader(input, strings.NewReader("\n"), f)
+		}
+		report, err := functions.CheckAddresses(database, input, nil)
+		if err != nil {
+			renderIndex(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Fehler bei der Prüfung: %v", err)})
 			return
 		}
-		ipUint := helpers.IPToUint32(parsed)
-		if ipUint == 0 {
-			c.HTML(http.StatusBadRequest, "index.html", gin.H{
-				"title":    "IP Blocklist Manager",
-				"error":    fmt.Sprintf("IP %s konnte nicht verarbeitet werden.", ipStr),
-				"BasePath": BasePath,
-			})
+		if len(report.Results) == 0 {
+			renderIndex(http.StatusBadRequest, gin.H{"error": "Bitte eine IP-Adresse, einen CIDR, einen Bereich oder eine Liste eingeben.", "unparsed": report.Unparsed})
 			return
 		}
-
-		foundEntry, err := db.FindPoolByIP(database, ipUint)
-		if err != nil {
-			c.HTML(http.StatusInternalServerError, "index.html", gin.H{
-				"title":    "IP Blocklist Manager",
-				"error":    fmt.Sprintf("Fehler bei der DB-Abfrage: %v", err),
-				"BasePath": BasePath,
-			})
+		if c.PostForm("format") == "csv" {
+			c.Header("Content-Disposition", `attachment; filename="blv-check.csv"`)
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			if err := functions.WriteCheckCSV(c.Writer, report.Results); err != nil {
+				app.LogIt.Error(fmt.Sprintf("CSV der Prüfung konnte nicht geschrieben werden: %v", err))
+			}
 			return
 		}
 
-		if foundEntry == nil {
-			c.HTML(http.StatusOK, "index.html", gin.H{
-				"title":    "IP Blocklist Manager",
-				"message":  fmt.Sprintf("IP %s ist nicht registriert.", ipStr),
-				"BasePath": BasePath,
-			})
-			return
+		inputs := make([]string, 0, len(report.Results))
+		for _, res := range report.Results {
+			inputs = append(inputs, res.Input)
 		}
-		var result string
-		switch foundEntry.Status {
-		case "w":
-			result = fmt.Sprintf("IP %s ist whitelisted (CIDR: %s).", ipStr, foundEntry.CIDR)
-		case "b":
-			result = fmt.Sprintf("IP %s ist geblockt (CIDR: %s).", ipStr, foundEntry.CIDR)
+		h := gin.H{
+			"report":   report,
+			"checked":  strings.Join(inputs, "\n"),
+			"unparsed": report.Unparsed,
 		}
-		c.HTML(http.StatusOK, "index.html", gin.H{
-			"title":    "IP Blocklist Manager",
-			"message":  result,
-			"poolName": foundEntry.Name,
-			"comment":  foundEntry.Comment,
-			"status":   foundEntry.Status,
-			"BasePath": BasePath,
-		})
+		// eine einzelne IP wie bisher mit dem spezifischsten Treffer melden
+		if res := report.Results[0]; len(report.Results) == 1 && res.Error == "" && res.Start == res.End {
+			if len(res.Entries) == 0 {
+				h["message"] = fmt.Sprintf("IP %s ist nicht registriert.", res.Input)
+			} else {
+				found := res.Entries[0]
+				switch found.Status {
+				case "w":
+					h["message"] = fmt.Sprintf("IP %s ist whitelisted (CIDR: %s).", res.Input, found.CIDR)
+				case "b":
+					h["message"] = fmt.Sprintf("IP %s ist geblockt (CIDR: %s).", res.Input, found.CIDR)
+				}
+				h["poolName"], h["comment"], h["status"] = found.Name, found.Comment, found.Status
+			}
+		} else {
+			h["message"] = fmt.Sprintf("%d von %d Angaben sind registriert.", report.Found, len(report.Results))
+		}
+		renderIndex(http.StatusOK, h)
 	})
 	// Übersicht aller Pools
 	r.GET("/pools", func(c *gin.Context) {
@@ -113,39 +126,478 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 		})
 	})
 
+	// JSON-API für Skripte und andere Dienste
+	registerAPI(r.Group("/api/v1"), database)
+	dr.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, BasePath+"/api/") {
+			apiError(c, http.StatusNotFound, "not_found", "unbekannter Pfad")
+		}
+	})
+
+	// Anmeldung für den Admin-Bereich
+	login := dr.Group("/admin", adminRequestLog)
+	renderLogin := func(c *gin.Context, status int, next, errMsg string) {
+		h := gin.H{
+			"title":    "Anmeldung",
+			"next":     next,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}
+		if app.Config.OIDC.Enabled {
+			h["oidc"] = app.Config.OIDC.Label
+		}
+		c.HTML(status, "login.html", h)
+	}
+	startSession := func(c *gin.Context, account *functions.Account, next string) {
+		id, err := functions.CreateSession(database, account.Name)
+		if err != nil {
+			renderLogin(c, http.StatusInternalServerError, next, err.Error())
+			return
+		}
+		c.Set(gin.AuthUserKey, account.Name)
+		setSessionCookie(c, id, int(app.Config.Session.MaxAge.Seconds()))
+		c.Redirect(http.StatusSeeOther, safeNext(next, BasePath+"/admin/"))
+	}
+	login.GET("/login", func(c *gin.Context) {
+		renderLogin(c, http.StatusOK, c.Query("next"), "")
+	})
+	login.POST("/login", func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("username"))
+		next := c.PostForm("next")
+		account, err := functions.AuthenticateUser(database, name, c.PostForm("password"))
+		if err != nil {
+			renderLogin(c, http.StatusInternalServerError, next, fmt.Sprintf("Fehler bei der Anmeldung: %v", err))
+			return
+		}
+		if account == nil {
+			renderLogin(c, http.StatusUnauthorized, next, "Benutzername oder Passwort ist falsch.")
+			return
+		}
+		startSession(c, account, next)
+	})
+	if app.Config.OIDC.Enabled {
+		login.GET("/oidc/login", func(c *gin.Context) {
+			next := c.Query("next")
+			authURL, state, err := functions.OIDCAuthURL(next)
+			if err != nil {
+				app.LogIt.Error(fmt.Sprintf("OIDC-Anmeldung konnte nicht beginnen: %v", err))
+				renderLogin(c, http.StatusBadGateway, next, fmt.Sprintf("Der Identity Provider ist nicht erreichbar: %v", err))
+				return
+			}
+			setOIDCStateCookie(c, state, int(functions.OIDCLoginTimeout.Seconds()))
+			c.Redirect(http.StatusFound, authURL)
+		})
+		login.GET("/oidc/callback", func(c *gin.Context) {
+			cookieState, _ := c.Cookie(oidcStateCookie)
+			setOIDCStateCookie(c, "", -1)
+			if e := c.Query("error"); e != "" {
+				app.LogIt.Info(fmt.Sprintf("OIDC-Anmeldung abgebrochen: %s %s", e, c.Query("error_description")))
+				renderLogin(c, http.StatusUnauthorized, "", fmt.Sprintf("Die Anmeldung wurde vom Identity Provider abgelehnt (%s).", e))
+				return
+			}
+			account, next, err := functions.OIDCCallback(database, c.Query("state"), cookieState, c.Query("code"))
+			if err != nil {
+				app.LogIt.Warn(fmt.Sprintf("OIDC-Anmeldung fehlgeschlagen: %v", err))
+				renderLogin(c, http.StatusUnauthorized, "", "Die Anmeldung über den Identity Provider ist fehlgeschlagen, bitte erneut versuchen.")
+				return
+			}
+			if account == nil {
+				renderLogin(c, http.StatusForbidden, next, "Für diesen Benutzer ist keine Rolle vorgesehen.")
+				return
+			}
+			startSession(c, account, next)
+		})
+	}
+
 	// Admin-Bereich
-	admin := dr.Group("/admin", gin.BasicAuth(gin.Accounts{
-		"dsrAdmin": "j?Fr@´@^>uA6K+1´w]", // user: admin, pass: secret
-	}))
+	admin := dr.Group("/admin", adminRequestLog, sessionAuth(database, BasePath), csrfProtect(BasePath))
+	admin.POST("/logout", func(c *gin.Context) {
+		if id, err := c.Cookie(sessionCookie); err == nil {
+			if err := functions.EndSession(database, id); err != nil {
+				app.LogIt.Error(fmt.Sprintf("Abmelden von %s fehlgeschlagen: %v", currentUser(c), err))
+			}
+		}
+		setSessionCookie(c, "", -1)
+		app.LogIt.Info(fmt.Sprintf("Benutzer %s abgemeldet", currentUser(c)))
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/login")
+	})
+	adminOnly := requireRole(BasePath, functions.RoleAdmin)
+	poolEditor := requirePoolRole(BasePath, functions.RoleEditor)
+	poolAdmin := requirePoolRole(BasePath, functions.RoleAdmin)
+	poolEntry := requirePoolEntry(database, BasePath)
 
 	// Adminseite
 	admin.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "admin.html", gin.H{
+		drifted, err := functions.DetectDrift(database)
+		var errMsg string
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Prüfen der Listen: %v", err)
+		}
+		c.HTML(http.StatusOK, "admin.html", pageData(c, gin.H{
 			"title":    "Administration",
+			"drifted":  drifted,
+			"error":    errMsg,
 			"BasePath": BasePath,
-		})
+		}))
 	})
-	admin.POST("/activate", func(c *gin.Context) {
-		err := functions.ExportDB2Conf(database)
-		names, err := db.ListPoolNames(database)
-		c.HTML(http.StatusOK, "pools.html", gin.H{
-			"title":    "IP Blocklist Manager",
-			"message":  fmt.Sprintf("%v Pools importiert", len(names)),
-			"pools":    names,
-			"error":    err,
+	// Vorschau der Aktivierung mit Diff je Datei
+	renderPreview := func(c *gin.Context, errMsg string) {
+		diffs, err := functions.PreviewActivation(database)
+		if err != nil {
+			c.HTML(http.StatusInternalServerError, "preview.html", pageData(c, gin.H{
+				"title":    "Aktivierung - Vorschau",
+				"error":    fmt.Sprintf("Fehler beim Erstellen der Vorschau: %v", err),
+				"BasePath": BasePath,
+			}))
+			return
+		}
+		drifted, err := functions.DetectDrift(database)
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Prüfen der Listen: %v", err)
+		}
+		changed := 0
+		for _, d := range diffs {
+			if d.Status != "unverändert" {
+				changed++
+			}
+		}
+		c.HTML(http.StatusOK, "preview.html", pageData(c, gin.H{
+			"title":       "Aktivierung - Vorschau",
+			"diffs":       diffs,
+			"fingerprint": functions.PreviewFingerprint(diffs),
+			"changed":     changed,
+			"drifted":     drifted,
+			"error":       errMsg,
+			"BasePath":    BasePath,
+		}))
+	}
+	admin.GET("/activate", adminOnly, func(c *gin.Context) {
+		renderPreview(c, c.Query("error"))
+	})
+	admin.POST("/activate", adminOnly, func(c *gin.Context) {
+		// von Hand geänderte Listen werden nur nach Bestätigung überschrieben
+		if c.PostForm("overwriteDrift") != "1" {
+			drifted, err := functions.DetectDrift(database)
+			if err != nil || len(drifted) > 0 {
+				renderPreview(c, "Listen wurden von Hand geändert - bitte übernehmen oder das Überschreiben bestätigen.")
+				return
+			}
+		}
+		// aktiviert wird nur, was in der Vorschau bestätigt wurde
+		result, err := functions.ActivatePreviewed(database, c.PostForm("fingerprint"))
+		if errors.Is(err, functions.ErrPreviewOutdated) {
+			renderPreview(c, "Datenbank oder Listen haben sich seit der Vorschau geändert - bitte die neue Vorschau prüfen und erneut bestätigen.")
+			return
+		}
+		var errMsg string
+		if err != nil {
+			errMsg = fmt.Sprintf("Aktivierung fehlgeschlagen: %v", err)
+		}
+		c.HTML(http.StatusOK, "activation.html", pageData(c, gin.H{
+			"title":    "Aktivierung",
+			"result":   result,
+			"error":    errMsg,
 			"BasePath": BasePath,
-		})
+		}))
 	})
-	admin.POST("/reset", func(c *gin.Context) {
-		err := functions.ResetDB(database)
-		names, err := db.ListPoolNames(database)
-		c.HTML(http.StatusOK, "pools.html", gin.H{
-			"title":    "IP Blocklist Manager",
-			"message":  fmt.Sprintf("%v Pools importiert", len(names)),
-			"pools":    names,
-			"error":    err,
+	// von Hand geänderte Liste in die Datenbank übernehmen
+	admin.POST("/drift/import", adminOnly, func(c *gin.Context) {
+		file := c.PostForm("file")
+		if err := functions.ImportDrift(database, file); err != nil {
+			c.Redirect(http.StatusSeeOther, BasePath+"/admin/activate?error="+url.QueryEscape(fmt.Sprintf("Fehler beim Übernehmen von %s: %v", file, err)))
+			return
+		}
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/activate")
+	})
+
+	// Datenbank mit den Listen abgleichen: GET zeigt nur die Unterschiede
+	admin.GET("/reconcile", adminOnly, func(c *gin.Context) {
+		report, err := functions.ReconcileDB(database, false)
+		var errMsg string
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Abgleich: %v", err)
+		}
+		h := gin.H{
+			"title":    "Abgleich mit den Listen",
+			"report":   report,
+			"error":    errMsg,
 			"BasePath": BasePath,
-		})
+		}
+		if report != nil {
+			h["fingerprint"] = functions.ReconcileFingerprint(report)
+		}
+		c.HTML(http.StatusOK, "reconcile.html", pageData(c, h))
+	})
+	admin.POST("/reconcile", adminOnly, func(c *gin.Context) {
+		// übernommen wird nur, was im Bericht angezeigt wurde
+		report, err := functions.ReconcileReviewed(database, c.PostForm("fingerprint"))
+		var message, errMsg string
+		if errors.Is(err, functions.ErrReconcileOutdated) {
+			c.HTML(http.StatusConflict, "reconcile.html", pageData(c, gin.H{
+				"title":       "Abgleich mit den Listen",
+				"report":      report,
+				"fingerprint": functions.ReconcileFingerprint(report),
+				"error":       "Datenbank oder Listen haben sich seit dem Bericht geändert - bitte den neuen Bericht prüfen und erneut übernehmen.",
+				"BasePath":    BasePath,
+			}))
+			return
+		}
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Abgleich: %v", err)
+		} else {
+			message = fmt.Sprintf("Datenbank abgeglichen: %d hinzugefügt, %d entfernt, %d geändert.", report.Added, report.Removed, report.Changed)
+		}
+		c.HTML(http.StatusOK, "reconcile.html", pageData(c, gin.H{
+			"title":    "Abgleich mit den Listen",
+			"report":   report,
+			"message":  message,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}))
+	})
+	// letzte Möglichkeit: Datenbank leeren und die Listen neu einlesen
+	admin.POST("/reset", adminOnly, func(c *gin.Context) {
+		if err := functions.ResetDB(database); err != nil {
+			c.HTML(http.StatusInternalServerError, "pools.html", gin.H{
+				"title":    "Pools",
+				"error":    fmt.Sprintf("Fehler beim Zurücksetzen der Datenbank: %v", err),
+				"BasePath": BasePath,
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, BasePath+"/pools")
+	})
+
+	// Feeds mit Stand und Verlauf
+	admin.GET("/feeds", func(c *gin.Context) {
+		feeds, err := functions.ListFeeds(database, 10)
+		var errMsg string
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Feeds: %v", err)
+		}
+		c.HTML(http.StatusOK, "feeds.html", pageData(c, gin.H{
+			"title":    "Feeds",
+			"feeds":    feeds,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}))
+	})
+	// Feed sofort abrufen
+	admin.POST("/feeds/:name/fetch", adminOnly, func(c *gin.Context) {
+		name := c.Param("name")
+		var message, errMsg string
+		if feed, ok := functions.FeedByName(name); !ok {
+			errMsg = fmt.Sprintf("Feed %s ist nicht konfiguriert.", name)
+		} else if h, err := functions.FetchFeed(database, feed); err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Abruf von %s: %v", name, err)
+		} else {
+			message = fmt.Sprintf("Feed %s %s: %d hinzugefügt, %d entfernt, %d geändert.", name, h.Result, h.Added, h.Removed, h.Changed)
+		}
+		feeds, err := functions.ListFeeds(database, 10)
+		if err != nil && errMsg == "" {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Feeds: %v", err)
+		}
+		c.HTML(http.StatusOK, "feeds.html", pageData(c, gin.H{
+			"title":    "Feeds",
+			"feeds":    feeds,
+			"message":  message,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}))
+	})
+
+	// API-Tokens verwalten
+	renderTokens := func(c *gin.Context, status int, newToken, message, errMsg string) {
+		tokens, err := db.ListAPITokens(database)
+		if err != nil && errMsg == "" {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Tokens: %v", err)
+		}
+		pools, _ := db.ListPoolNames(database)
+		c.HTML(status, "tokens.html", pageData(c, gin.H{
+			"title":    "API-Tokens",
+			"tokens":   tokens,
+			"scopes":   functions.TokenScopes,
+			"pools":    pools,
+			"newToken": newToken,
+			"message":  message,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}))
+	}
+	admin.GET("/tokens", adminOnly, func(c *gin.Context) {
+		renderTokens(c, http.StatusOK, "", "", "")
+	})
+	admin.POST("/tokens", adminOnly, func(c *gin.Context) {
+		name := c.PostForm("name")
+		plain, err := functions.CreateToken(database, name, c.PostFormArray("scopes"), splitPools(c.PostForm("pools")), c.PostForm("expiresAt"))
+		if err != nil {
+			renderTokens(c, http.StatusBadRequest, "", "", err.Error())
+			return
+		}
+		renderTokens(c, http.StatusOK, plain, fmt.Sprintf("Token %s angelegt. Es wird nur jetzt angezeigt.", name), "")
+	})
+	admin.POST("/tokens/:id/revoke", adminOnly, func(c *gin.Context) {
+		if err := db.DeleteAPIToken(database, c.Param("id")); err != nil {
+			renderTokens(c, http.StatusInternalServerError, "", "", fmt.Sprintf("Fehler beim Widerrufen: %v", err))
+			return
+		}
+		app.LogIt.Info(fmt.Sprintf("API-Token %s widerrufen", c.Param("id")))
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/tokens")
+	})
+
+	// Benutzer, Rollen und Pools verwalten
+	renderUsers := func(c *gin.Context, status int, message, errMsg string) {
+		accounts, err := functions.ListAccounts(database)
+		if err != nil && errMsg == "" {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Benutzer: %v", err)
+		}
+		pools, _ := db.ListPoolNames(database)
+		c.HTML(status, "users.html", pageData(c, gin.H{
+			"title":    "Benutzer",
+			"accounts": accounts,
+			"roles":    functions.Roles,
+			"pools":    pools,
+			"message":  message,
+			"error":    errMsg,
+			"BasePath": BasePath,
+		}))
+	}
+	admin.GET("/users", adminOnly, func(c *gin.Context) {
+		renderUsers(c, http.StatusOK, "", "")
+	})
+	admin.POST("/users", adminOnly, func(c *gin.Context) {
+		name := strings.TrimSpace(c.PostForm("name"))
+		if err := functions.AddUser(database, name, c.PostForm("password"), c.PostForm("role"), splitPools(c.PostForm("pools"))); err != nil {
+			renderUsers(c, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		renderUsers(c, http.StatusOK, fmt.Sprintf("Benutzer %s angelegt.", name), "")
+	})
+	admin.POST("/users/:user/role", adminOnly, func(c *gin.Context) {
+		if err := functions.SetRole(database, c.Param("user"), c.PostForm("role"), splitPools(c.PostForm("pools"))); err != nil {
+			renderUsers(c, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/users")
+	})
+	admin.POST("/users/:user/delete", adminOnly, func(c *gin.Context) {
+		if err := functions.DeleteUser(database, c.Param("user")); err != nil {
+			renderUsers(c, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, BasePath+"/admin/users")
+	})
+
+	// Übersicht der Backups
+	admin.GET("/backups", adminOnly, func(c *gin.Context) {
+		backups, err := functions.ListBackups()
+		var errMsg string
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Backups: %v", err)
+		}
+		dbBackups, err := functions.ListDBBackups()
+		if err != nil {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Datenbank-Backups: %v", err)
+		}
+		c.HTML(http.StatusOK, "backups.html", pageData(c, gin.H{
+			"title":     "Backups",
+			"backups":   backups,
+			"dbBackups": dbBackups,
+			"error":     errMsg,
+			"BasePath":  BasePath,
+		}))
+	})
+	// Datenbank sofort sichern
+	admin.POST("/backups/db", adminOnly, func(c *gin.Context) {
+		var message, errMsg string
+		if path, err := functions.BackupDB(database); err != nil {
+			errMsg = err.Error()
+		} else {
+			message = "Datenbank gesichert nach " + path
+		}
+		backups, _ := functions.ListBackups()
+		dbBackups, _ := functions.ListDBBackups()
+		c.HTML(http.StatusOK, "backups.html", pageData(c, gin.H{
+			"title":     "Backups",
+			"backups":   backups,
+			"dbBackups": dbBackups,
+			"message":   message,
+			"error":     errMsg,
+			"BasePath":  BasePath,
+		}))
+	})
+	// Backup in die Listen oder die Datenbank zurückspielen
+	admin.POST("/backups/:name/restore", adminOnly, func(c *gin.Context) {
+		name := c.Param("name")
+		var message, errMsg, output string
+		var err error
+		switch c.PostForm("target") {
+		case "db":
+			err = functions.RestoreBackupToDB(database, name)
+			message = fmt.Sprintf("Backup %s in die Datenbank wiederhergestellt.", name)
+		case "files":
+			output, err = functions.RestoreBackupToFiles(database, name)
+			message = fmt.Sprintf("Backup %s in die Listen wiederhergestellt.", name)
+		default:
+			err = fmt.Errorf("unbekanntes Ziel %q", c.PostForm("target"))
+		}
+		if err != nil {
+			message = ""
+			errMsg = fmt.Sprintf("Fehler beim Wiederherstellen von %s: %v", name, err)
+		}
+		backups, listErr := functions.ListBackups()
+		if listErr != nil && errMsg == "" {
+			errMsg = fmt.Sprintf("Fehler beim Lesen der Backups: %v", listErr)
+		}
+		dbBackups, _ := functions.ListDBBackups()
+		c.HTML(http.StatusOK, "backups.html", pageData(c, gin.H{
+			"title":     "Backups",
+			"backups":   backups,
+			"dbBackups": dbBackups,
+			"message":   message,
+			"output":    output,
+			"error":     errMsg,
+			"BasePath":  BasePath,
+		}))
+	})
+
+	// Suche über alle Pools
+	admin.GET("/search", func(c *gin.Context) {
+		q, err := searchQuery(c)
+		h := gin.H{
+			"title":    "Suche",
+			"q":        q,
+			"back":     c.Request.URL.RequestURI(),
+			"BasePath": BasePath,
+		}
+		// ohne Angaben nur das Formular zeigen
+		if err == nil && len(c.Request.URL.Query()) > 0 {
+			var res *functions.SearchResult
+			if res, err = functions.Search(database, q); err == nil {
+				query := c.Request.URL.Query()
+				sortURLs := map[string]string{}
+				for _, key := range functions.SearchSorts {
+					dir := "asc"
+					if key == q.Sort && !q.Desc {
+						dir = "desc"
+					}
+					sortURLs[key] = searchURL(c.Request.URL.Path, query, "sort", key, "dir", dir, "page", "1")
+				}
+				h["result"], h["sortURLs"] = res, sortURLs
+				if res.Page > 1 {
+					h["prevURL"] = searchURL(c.Request.URL.Path, query, "page", strconv.Itoa(res.Page-1))
+				}
+				if res.Page < res.Pages {
+					h["nextURL"] = searchURL(c.Request.URL.Path, query, "page", strconv.Itoa(res.Page+1))
+				}
+			}
+		}
+		status := http.StatusOK
+		if err != nil {
+			h["error"] = err.Error()
+			status = http.StatusBadRequest
+		}
+		c.HTML(status, "search.html", pageData(c, h))
 	})
 
 	// Detailseite für einen Pool
@@ -161,103 +613,98 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 			poolStatus = "w"
 		}
 		if err != nil {
-			c.HTML(http.StatusInternalServerError, "pool_detail.html", gin.H{
+			c.HTML(http.StatusInternalServerError, "pool_detail.html", pageData(c, gin.H{
 				"title":    "Pool " + poolName,
+				"pool":     poolName,
 				"error":    fmt.Sprintf("Fehler beim Laden des Pools: %v", err),
 				"BasePath": BasePath,
-			})
+			}))
 			return
 		}
 		errCode := c.Query("error")
+		feed, isFeed := functions.FeedByName(poolName)
+		// Zielpools zum Verschieben
+		var targets []string
+		if names, err := db.ListPoolNames(database); err == nil {
+			for _, name := range names {
+				if name != poolName && currentAccount(c).CanEdit(name) {
+					targets = append(targets, name)
+				}
+			}
+		}
 
-		c.HTML(http.StatusOK, "pool_detail.html", gin.H{
+		c.HTML(http.StatusOK, "pool_detail.html", pageData(c, gin.H{
 			"title":      "Pool " + poolName,
 			"pool":       poolName,
 			"poolStatus": poolStatus,
 			"entries":    entries,
+			"isFeed":     isFeed,
+			"feed":       feed,
+			"targets":    targets,
 			"error":      errCode,
+			"message":    c.Query("message"),
 			"BasePath":   BasePath,
-		})
+		}))
 	})
 
 	// Pool exportieren
-	admin.POST("/pools/:name/export", func(c *gin.Context) {
+	admin.POST("/pools/:name/export", poolEditor, func(c *gin.Context) {
 		poolName := c.Param("name")
 		wCount, bCount, err := functions.ExportConf(database, poolName, app.Config.OutputPath)
 		count := wCount + bCount
 		if err != nil {
-			c.HTML(http.StatusSeeOther, "pool_detail.html", gin.H{
-				"title":    "Pool " + poolName,
-				"error":    fmt.Sprintf("Fehler beim Export des Pools: %v", err),
-				"BasePath": BasePath,
-			})
-			return
-		}
-		entries, err := db.ListByPool(database, poolName)
-		c.HTML(http.StatusOK, "pool_detail.html", gin.H{
-			"title":    "Pool " + poolName,
-			"message":  fmt.Sprintf("%v items exportiert", count),
-			"entries":  entries,
-			"BasePath": BasePath,
-		})
-	})
-	// Pool aktivieren
-	admin.POST("/pools/:name/activate", func(c *gin.Context) {
-		poolName := c.Param("name")
-		wCount, bCount, err := functions.ExportConf(database, poolName, app.Config.ListPath)
-		count := wCount + bCount
-		if err != nil {
-			c.HTML(http.StatusSeeOther, "pool_detail.html", gin.H{
+			c.HTML(http.StatusSeeOther, "pool_detail.html", pageData(c, gin.H{
 				"title":    "Pool " + poolName,
+				"pool":     poolName,
 				"error":    fmt.Sprintf("Fehler beim Export des Pools: %v", err),
 				"BasePath": BasePath,
-			})
+			}))
 			return
 		}
 		entries, err := db.ListByPool(database, poolName)
-		c.HTML(http.StatusOK, "pool_detail.html", gin.H{
+		c.HTML(http.StatusOK, "pool_detail.html", pageData(c, gin.H{
 			"title":    "Pool " + poolName,
+			"pool":     poolName,
 			"message":  fmt.Sprintf("%v items exportiert", count),
 			"entries":  entries,
 			"BasePath": BasePath,
-		})
+		}))
 	})
-
 	// Pool whitelisten
-	admin.POST("/pools/:name/whitelist", func(c *gin.Context) {
+	admin.POST("/pools/:name/whitelist", poolEditor, func(c *gin.Context) {
 		poolName := c.Param("name")
 		foundEntries, err := db.WhitelistPool(database, poolName)
 		if err != nil {
 			c.Redirect(http.StatusInternalServerError, BasePath+"/admin/pools/"+poolName+"?error=Fehler beim whitelisten")
 		}
 		if foundEntries != nil {
-			c.HTML(http.StatusOK, "found.html", gin.H{
+			c.HTML(http.StatusOK, "found.html", pageData(c, gin.H{
 				"title":    "Pool " + poolName,
 				"error":    fmt.Sprintf("%v Einträge sind geblockt - bitte erst lösen", len(foundEntries)),
 				"entries":  foundEntries,
 				"poolName": poolName,
-			})
+			}))
 			return
 		}
 		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName)
 	})
 
 	// Pool blocken
-	admin.POST("/pools/:name/block", func(c *gin.Context) {
+	admin.POST("/pools/:name/block", poolEditor, func(c *gin.Context) {
 		poolName := c.Param("name")
 		_ = db.BlockPool(database, poolName)
 		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName)
 	})
 
 	// Pool löschen
-	admin.POST("/pools/:name/delete", func(c *gin.Context) {
+	admin.POST("/pools/:name/delete", poolAdmin, func(c *gin.Context) {
 		poolName := c.Param("name")
 		_ = db.DeletePool(database, poolName)
 		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/")
 	})
 
 	// Eintrag hinzufügen
-	admin.POST("/pools/:name/addIP", func(c *gin.Context) {
+	admin.POST("/pools/:name/addIP", poolEditor, func(c *gin.Context) {
 		poolName := c.Param("name")
 		cidr := strings.TrimSpace(c.PostForm("cidr"))
 		comment := strings.TrimSpace(c.PostForm("comment"))
@@ -265,7 +712,19 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 			c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName+"?error=cidr_empty")
 			return
 		}
-		existingEntry, err := db.InsertEntry(database, cidr, poolName, comment, "b")
+		var existingEntry *db.PoolEntry
+		var err error
+		if helpers.StartsWithIPRange(cidr) {
+			// Bereiche werden als minimale Menge von CIDRs mit gemeinsamer Gruppe angelegt
+			start, end, rangeErr := helpers.ParseIPRange(cidr)
+			if rangeErr != nil {
+				c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName+"?error="+rangeErr.Error())
+				return
+			}
+			existingEntry, err = db.InsertRangeEntry(database, helpers.RangeToCIDRs(start, end), poolName, comment, "b")
+		} else {
+			existingEntry, err = db.InsertEntry(database, cidr, poolName, comment, "b")
+		}
 		if err != nil {
 			c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName+"?error="+err.Error())
 			return
@@ -279,25 +738,32 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 				result = fmt.Sprintf("CIDR %s ist geblockt und wird nicht hinzugefügt", existingEntry.CIDR)
 			}
 			entries, _ := db.ListByPool(database, poolName)
-			c.HTML(http.StatusOK, "pool_detail.html", gin.H{
+			c.HTML(http.StatusOK, "pool_detail.html", pageData(c, gin.H{
 				"title":    "IP Blocklist Manager",
+				"pool":     existingEntry.Name,
 				"error":    result,
 				"poolName": existingEntry.Name,
 				"comment":  existingEntry.Comment,
 				"status":   existingEntry.Status,
 				"entries":  entries,
 				"BasePath": BasePath,
-			})
+			}))
+			return
 		}
 		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName)
 	})
 
 	// Eintrag whitelisten
-	admin.POST("/pools/:name/whitelistIP", func(c *gin.Context) {
+	admin.POST("/pools/:name/whitelistIP", poolEditor, poolEntry, func(c *gin.Context) {
 		poolName := c.Param("name")
 		entryID := c.PostForm("entryID")
+		groupID := c.PostForm("groupID")
 		var m string
-		if entryID != "" {
+		if groupID != "" {
+			if err := db.WhitelistByGroup(database, groupID); err != nil {
+				app.LogIt.Debug(fmt.Sprintf("Fehler beim Whitelisten der Gruppe %s : %v", groupID, err))
+			}
+		} else if entryID != "" {
 			if err := db.WhitelistByID(database, entryID); err != nil {
 				app.LogIt.Debug(fmt.Sprintf("Fehler beim Whitelisten der ID %s : %v", entryID, err))
 			}
@@ -305,15 +771,20 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 			m = "?error=Fehler beim Whitelisten - keine ID übergeben"
 			app.LogIt.Debug(m)
 		}
-		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName+m)
+		c.Redirect(http.StatusSeeOther, backTo(c, BasePath+"/admin/pools/"+poolName, m))
 	})
 
 	// Eintrag blocken
-	admin.POST("/pools/:name/blockIP", func(c *gin.Context) {
+	admin.POST("/pools/:name/blockIP", poolEditor, poolEntry, func(c *gin.Context) {
 		poolName := c.Param("name")
 		entryID := c.PostForm("entryID")
+		groupID := c.PostForm("groupID")
 		var m string
-		if entryID != "" {
+		if groupID != "" {
+			if err := db.BlockByGroup(database, groupID); err != nil {
+				app.LogIt.Debug(fmt.Sprintf("Fehler beim Blocken der Gruppe %s : %v", groupID, err))
+			}
+		} else if entryID != "" {
 			if err := db.BlockByID(database, entryID); err != nil {
 				app.LogIt.Debug(fmt.Sprintf("Fehler beim Blocken der ID %s : %v", entryID, err))
 			}
@@ -321,21 +792,56 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 			m = "?error=Fehler beim Blocken - keine ID übergeben"
 			app.LogIt.Debug(m)
 		}
-		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName+m)
+		c.Redirect(http.StatusSeeOther, backTo(c, BasePath+"/admin/pools/"+poolName, m))
 	})
 
 	// Eintrag löschen
-	admin.POST("/pools/:name/deleteIP", func(c *gin.Context) {
+	admin.POST("/pools/:name/deleteIP", poolEditor, poolEntry, func(c *gin.Context) {
 		poolName := c.Param("name")
 		entryID := c.PostForm("entryID")
-		if entryID != "" {
+		groupID := c.PostForm("groupID")
+		if groupID != "" {
+			_ = db.DeleteByGroup(database, groupID)
+		} else if entryID != "" {
 			_ = db.DeleteByID(database, entryID)
 		}
-		c.Redirect(http.StatusSeeOther, BasePath+"/admin/pools/"+poolName)
+		c.Redirect(http.StatusSeeOther, backTo(c, BasePath+"/admin/pools/"+poolName, ""))
+	})
+
+	// Sammelaktion für die ausgewählten Einträge
+	admin.POST("/pools/:name/bulk", poolEditor, func(c *gin.Context) {
+		poolName := c.Param("name")
+		req := functions.BulkRequest{
+			Pool:      poolName,
+			Action:    c.PostForm("action"),
+			IDs:       c.PostFormArray("ids"),
+			Target:    strings.TrimSpace(c.PostForm("target")),
+			ExpiresAt: c.PostForm("expiresAt"),
+		}
+		if req.Action == functions.BulkMove && req.Target != "" && !currentAccount(c).CanEdit(req.Target) {
+			forbidden(c, BasePath, fmt.Sprintf("Dafür ist die Rolle %s im Pool %s nötig.", functions.RoleEditor, req.Target))
+			return
+		}
+		poolURL := BasePath + "/admin/pools/" + poolName
+		n, err := functions.BulkEntries(database, req)
+		if err != nil {
+			app.LogIt.Debug(fmt.Sprintf("Sammelaktion %s in Pool %s fehlgeschlagen: %v", req.Action, poolName, err))
+			c.Redirect(http.StatusSeeOther, poolURL+"?error="+url.QueryEscape("Fehler bei der Sammelaktion: "+err.Error()))
+			return
+		}
+		done := map[string]string{
+			functions.BulkBlock:     "geblockt",
+			functions.BulkWhitelist: "gewhitelistet",
+			functions.BulkDelete:    "gelöscht",
+			functions.BulkMove:      "nach Pool " + req.Target + " verschoben",
+			functions.BulkExpire:    "mit neuem Ablaufdatum",
+		}[req.Action]
+		app.LogIt.Info(fmt.Sprintf("Admin %s: %d Einträge in Pool %s %s (%d ausgewählt)", currentUser(c), n, poolName, done, len(req.IDs)))
+		c.Redirect(http.StatusSeeOther, poolURL+"?message="+url.QueryEscape(fmt.Sprintf("%d Einträge %s", n, done)))
 	})
 
 	// HTML: Upload einer *.conf mit ImportConf
-	admin.POST("/pools/upload", func(c *gin.Context) {
+	admin.POST("/pools/upload", adminOnly, func(c *gin.Context) {
 		fileHeader, err := c.FormFile("file")
 		if err != nil {
 			c.HTML(http.StatusBadRequest, "pools.html", gin.H{
@@ -363,8 +869,16 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 		defer f.Close()
 
 		zielStatus := c.PostForm("zielStatus")
+		if zielStatus != "" && zielStatus != "w" && zielStatus != "b" {
+			c.HTML(http.StatusBadRequest, "index.html", gin.H{
+				"title":    "IP Blocklist Manager",
+				"error":    "Der Status muss w oder b sein.",
+				"BasePath": BasePath,
+			})
+			return
+		}
 
-		err = functions.ImportConf(database, f, poolName, zielStatus)
+		result, err := functions.ImportConf(database, f, poolName, zielStatus)
 		if err != nil {
 			c.HTML(http.StatusInternalServerError, "index.html", gin.H{
 				"title":    "IP Blocklist Manager",
@@ -376,7 +890,8 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 		names, err := db.ListPoolNames(database)
 		c.HTML(http.StatusOK, "pools.html", gin.H{
 			"title":    "IP Blocklist Manager",
-			"message":  fmt.Sprintf("Liste '%s' importiert.", poolName),
+			"message":  fmt.Sprintf("Liste '%s' importiert (%d Einträge).", poolName, result.Imported),
+			"unparsed": result.Unparsed,
 			"pools":    names,
 			"BasePath": BasePath,
 		})
@@ -384,3 +899,62 @@ func NewRouter(database *sql.DB, BasePath string) *gin.Engine {
 
 	return dr
 }
+
+// splitPools zerlegt eine kommagetrennte Liste von Pools aus einem Formular
+func splitPools(s string) []string {
+	var pools []string
+	for _, pool := range strings.Split(s, ",") {
+		if pool = strings.TrimSpace(pool); pool != "" {
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+// backTo liefert das Ziel nach einer Aktion: die Seite aus dem Formularfeld
+// back, z.B. die Suche, sonst pool. Fehler landen immer auf pool.
+func backTo(c *gin.Context, pool, errQuery string) string {
+	if errQuery != "" {
+		return pool + errQuery
+	}
+	return safeNext(c.PostForm("back"), pool)
+}
+
+// searchQuery liest eine Suche aus den Parametern der Anfrage, für das
+// Formular wie für die API
+func searchQuery(c *gin.Context) (functions.SearchQuery, error) {
+	q := functions.SearchQuery{
+		IP:      c.Query("ip"),
+		Match:   c.Query("match"),
+		Comment: c.Query("comment"),
+		Pool:    c.Query("pool"),
+		Status:  c.Query("status"),
+		Sort:    c.Query("sort"),
+		Desc:    c.Query("dir") == "desc",
+	}
+	var err error
+	if page := c.Query("page"); page != "" {
+		if q.Page, err = strconv.Atoi(page); err != nil {
+			return q, fmt.Errorf("page muss eine Zahl sein")
+		}
+	}
+	if perPage := c.Query("perPage"); perPage != "" {
+		if q.PerPage, err = strconv.Atoi(perPage); err != nil {
+			return q, fmt.Errorf("perPage muss eine Zahl sein")
+		}
+	}
+	return q, nil
+}
+
+// searchURL liefert path mit den Parametern aus query, in denen die
+// Schlüssel-Wert-Paare aus set ersetzt sind
+func searchURL(path string, query url.Values, set ...string) string {
+	v := url.Values{}
+	for key, values := range query {
+		v[key] = values
+	}
+	for i := 0; i+1 < len(set); i += 2 {
+		v.Set(set[i], set[i+1])
+	}
+	return path + "?" + v.Encode()
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+
+	"golang.org/x/term"
 
 	app "github.com/SvenKethz/fairdb/internal/configuration"
 	"github.com/SvenKethz/fairdb/internal/db"
@@ -18,8 +22,51 @@ var (
 	ConfigPath         = flag.String("c", "/etc/fairdb/conf.d/fairdb.yml", "use -c to provide a custom path to the config file")
 	DBinit             = flag.Bool("init", false, "Neuaufbau der Datenbank erzwingen")
 	Reset              = flag.Bool("reset", false, "Neuaufbau der Datenbank erzwingen")
+	Reconcile          = flag.Bool("reconcile", false, "Datenbank mit den Listen im ListPath abgleichen")
+	DryRun             = flag.Bool("dryRun", false, "mit -reconcile: Unterschiede nur anzeigen")
+	FetchFeeds         = flag.Bool("fetchFeeds", false, "alle konfigurierten Feeds sofort abrufen")
+	Exports            = flag.Bool("exports", false, "zusätzliche Exporte (Templates, ModSecurity, AWS WAF, Cloudflare) ohne Aktivierung schreiben")
+	Backups            = flag.Bool("backups", false, "vorhandene Backups auflisten")
+	Restore            = flag.String("restore", "", "Backup mit diesem Namen wiederherstellen (siehe -backups)")
+	RestoreTo          = flag.String("restoreTo", "files", "Ziel der Wiederherstellung: files oder db")
+	UserAdd            = flag.String("useradd", "", "Benutzer für den Admin-Bereich anlegen, das Passwort wird abgefragt")
+	Passwd             = flag.String("passwd", "", "Passwort eines Benutzers neu setzen")
+	UserRole           = flag.String("userrole", "", "Rolle und Pools eines Benutzers mit -role und -pools neu setzen")
+	Role               = flag.String("role", "viewer", "mit -useradd oder -userrole: viewer, editor oder admin")
+	Pools              = flag.String("pools", "", "mit -useradd oder -userrole: kommagetrennte Pools, für die die Rolle gilt (leer für alle)")
+	HashPassword       = flag.Bool("hashPassword", false, "bcrypt-Hash für einen Benutzer unter users in der Konfiguration erzeugen")
+	LDAPCheck          = flag.String("ldapCheck", "", "Anmeldung dieses Benutzers gegen LDAP prüfen und Gruppen und Rolle anzeigen")
 )
 
+// readPassword fragt das Passwort am Terminal zweimal ohne Echo ab. Ohne
+// Terminal wird die erste Zeile der Standardeingabe gelesen.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, "Passwort: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Passwort wiederholen: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", fmt.Errorf("die Passwörter stimmen nicht überein")
+	}
+	return string(first), nil
+}
+
 func main() {
 	flag.Parse()
 
@@ -41,7 +88,42 @@ func main() {
 	app.LogIt.Debug("LogLevel:       " + app.Config.Logcfg.LogLevel)
 	app.LogIt.Debug("LogFolder:      " + app.Config.Logcfg.LogFolder)
 
-	if *DBinit {
+	if *HashPassword {
+		password, err := readPassword()
+		if err != nil {
+			log.Fatalf("Fehler beim Lesen des Passworts: %v", err)
+		}
+		hash, err := functions.HashPassword(password)
+		if err != nil {
+			log.Fatalf("Fehler beim Erzeugen des Hashes: %v", err)
+		}
+		fmt.Println(hash)
+	} else if *LDAPCheck != "" {
+		if !app.Config.LDAP.Enabled {
+			log.Fatalf("LDAP ist nicht aktiviert (ldap.enabled)")
+		}
+		password, err := readPassword()
+		if err != nil {
+			log.Fatalf("Fehler beim Lesen des Passworts: %v", err)
+		}
+		user, err := functions.LDAPAuthenticate(*LDAPCheck, password)
+		if err != nil {
+			log.Fatalf("Fehler: %v", err)
+		}
+		if user == nil {
+			fmt.Println("Benutzer unbekannt oder Passwort falsch")
+			os.Exit(1)
+		}
+		fmt.Println("DN:", user.DN)
+		for _, g := range user.Groups {
+			fmt.Println("Gruppe:", g)
+		}
+		if user.Role == "" {
+			fmt.Println("keine Rolle - Anmeldung nicht möglich")
+			os.Exit(1)
+		}
+		fmt.Println("Rolle:", user.Role, "Pools:", strings.Join(user.Pools, ","))
+	} else if *DBinit {
 		app.LogIt.Info("Die DB wird initialisiert.")
 		if helpers.FileExists(app.Config.DbPath) {
 			os.Remove(app.Config.DbPath)
@@ -62,14 +144,131 @@ func main() {
 			log.Fatalf("Fehler beim Öffnen der Datenbank: %v", err)
 		}
 		defer database.Close()
+		if err := db.MigrateTables(database); err != nil {
+			log.Fatalf("Fehler beim Aktualisieren der Datenbank: %v", err)
+		}
 
-		if *Reset {
+		var pools []string
+		for _, pool := range strings.Split(*Pools, ",") {
+			if pool = strings.TrimSpace(pool); pool != "" {
+				pools = append(pools, pool)
+			}
+		}
+		if *UserRole != "" {
+			if err := functions.SetRole(database, *UserRole, *Role, pools); err != nil {
+				log.Fatalf("Fehler: %v", err)
+			}
+			fmt.Println("gespeichert")
+		} else if *UserAdd != "" || *Passwd != "" {
+			password, err := readPassword()
+			if err != nil {
+				log.Fatalf("Fehler beim Lesen des Passworts: %v", err)
+			}
+			if *UserAdd != "" {
+				err = functions.AddUser(database, *UserAdd, password, *Role, pools)
+			} else {
+				err = functions.SetPassword(database, *Passwd, password)
+			}
+			if err != nil {
+				log.Fatalf("Fehler: %v", err)
+			}
+			fmt.Println("gespeichert")
+		} else if *Backups {
+			backups, err := functions.ListBackups()
+			if err != nil {
+				log.Fatalf("Fehler beim Lesen der Backups: %v", err)
+			}
+			for _, b := range backups {
+				fmt.Printf("%-24s %4d Dateien %8d Einträge\n", b.Name, len(b.Files), b.Entries)
+			}
+			dbBackups, err := functions.ListDBBackups()
+			if err != nil {
+				log.Fatalf("Fehler beim Lesen der Datenbank-Backups: %v", err)
+			}
+			for _, b := range dbBackups {
+				fmt.Printf("%-24s %d Bytes\n", b.Name, b.Size)
+			}
+		} else if *Restore != "" {
+			switch *RestoreTo {
+			case "db":
+				err = functions.RestoreBackupToDB(database, *Restore)
+			case "files":
+				var output string
+				output, err = functions.RestoreBackupToFiles(database, *Restore)
+				fmt.Print(output)
+			default:
+				log.Fatalf("unbekanntes Ziel %q für -restoreTo (files oder db)", *RestoreTo)
+			}
+			if err != nil {
+				log.Fatalf("Fehler beim Wiederherstellen von %s: %v", *Restore, err)
+			}
+			fmt.Println("Backup", *Restore, "wiederhergestellt nach", *RestoreTo)
+		} else if *Reconcile {
+			report, err := functions.ReconcileDB(database, !*DryRun)
+			if err != nil {
+				log.Fatalf("Fehler beim Abgleich: %v", err)
+			}
+			for _, p := range report.Pools {
+				for _, cidr := range p.Added {
+					fmt.Printf("%s: + %s\n", p.Pool, cidr)
+				}
+				for _, cidr := range p.Removed {
+					fmt.Printf("%s: - %s\n", p.Pool, cidr)
+				}
+				for _, change := range p.Changed {
+					fmt.Printf("%s: ~ %s\n", p.Pool, change)
+				}
+			}
+			for _, u := range report.Unparsed {
+				fmt.Println("nicht verstanden:", u)
+			}
+			for _, pool := range report.Skipped {
+				fmt.Println("nicht abgeglichen wegen nicht verstandener Zeilen:", pool)
+			}
+			fmt.Printf("%d hinzugefügt, %d entfernt, %d geändert", report.Added, report.Removed, report.Changed)
+			if !report.Applied {
+				fmt.Print(" (nicht übernommen)")
+			}
+			fmt.Println()
+		} else if *FetchFeeds {
+			failed := false
+			for _, feed := range app.Config.Feeds {
+				h, err := functions.FetchFeed(database, feed)
+				if err != nil {
+					failed = true
+					fmt.Printf("%s: Fehler: %v\n", feed.Name, err)
+					continue
+				}
+				fmt.Printf("%s: %s, %d hinzugefügt, %d entfernt, %d geändert\n", feed.Name, h.Result, h.Added, h.Removed, h.Changed)
+			}
+			if failed {
+				os.Exit(1)
+			}
+		} else if *Exports {
+			written, err := functions.WriteExports(database)
+			for _, path := range written {
+				fmt.Println(path)
+			}
+			if err != nil {
+				log.Fatalf("Fehler beim Schreiben der Exporte: %v", err)
+			}
+		} else if *Reset {
 			app.LogIt.Info("Die DB wird nun zurückgesetzt und die Apache-Listen neu geladen - was kann etwas dauern.")
 			fmt.Println("Die DB wird nun zurückgesetzt und die Apache-Listen neu geladen - was kann etwas dauern.")
 			functions.ResetDB(database)
 			app.LogIt.Info("Die DB wurde zurückgesetzt und die Apache-Listen neu geladen.")
 			fmt.Println("Die DB wurde zurückgesetzt und die Apache-Listen neu geladen.")
 		} else {
+			if ok, err := functions.HasUsers(database); err != nil {
+				log.Fatalf("Fehler beim Lesen der Benutzer: %v", err)
+			} else if !ok {
+				app.LogIt.Warn("Es gibt keine Benutzer, der Admin-Bereich ist nicht erreichbar. Benutzer mit -useradd anlegen.")
+				fmt.Println("Es gibt keine Benutzer, der Admin-Bereich ist nicht erreichbar. Benutzer mit -useradd anlegen.")
+			}
+			functions.LogDrift(database)
+			functions.StartDBBackups(database, app.Config.DBBackupInterval)
+			functions.StartFeeds(database)
+			functions.StartExpiry(database)
 			r := webserver.NewRouter(database, app.Config.BasePath)
 			addr := fmt.Sprintf(":%d", app.Config.WebPort)
 			log.Printf("Starte Webserver auf %s ...", addr)